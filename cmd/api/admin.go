@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"greenlight.fyerfyer.net/internal/authz"
+	"greenlight.fyerfyer.net/internal/data"
+	"greenlight.fyerfyer.net/internal/validator"
+)
+
+// recordAudit writes an audit log entry for an admin action, a failure is
+// logged rather than returned since the action itself has already happened
+func (app *application) recordAudit(c *gin.Context, action string, targetID int64, details map[string]string) {
+	actor := c.Value("user").(*data.User)
+
+	if details == nil {
+		details = map[string]string{}
+	}
+	details["ip"] = app.clientIP(c)
+
+	err := app.models.AuditLogModel.AuditLogs.Insert(c.Request.Context(), &data.AuditLog{
+		ActorID:    actor.ID,
+		Action:     action,
+		TargetType: "user",
+		TargetID:   targetID,
+		Details:    details,
+	})
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{
+			"request_id": c.GetString("request_id"),
+			"action":     action,
+			"actor_id":   strconv.FormatInt(actor.ID, 10),
+			"target_id":  strconv.FormatInt(targetID, 10),
+		})
+	}
+}
+
+// adminTargetUser looks up the user named by the :id parameter, writing the
+// error response itself when it returns nil
+func (app *application) adminTargetUser(c *gin.Context) *data.User {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(c)
+		return nil
+	}
+
+	user, err := app.models.UserModel.Users.Get(c.Request.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return nil
+	}
+
+	return user
+}
+
+func (app *application) listUsersHandler(c *gin.Context) {
+	var input struct {
+		Query  string
+		Filter data.Filters
+	}
+
+	v := validator.New()
+	values := c.Request.URL.Query()
+
+	input.Query = app.readString(values, "q", "")
+	input.Filter.Page = app.readInt(values, "page", 1, v)
+	input.Filter.PageSize = app.readInt(values, "page_size", 20, v)
+	input.Filter.Sort = app.readString(values, "sort", "id")
+	input.Filter.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filter); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.UserModel.Users.GetAll(c.Request.Context(), input.Query, input.Filter)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = writeJSONList(c, http.StatusOK, "users", users, envelope{"metadata": metadata})
+	if err != nil {
+		// past the first byte the best we can do is log the failure
+		if c.Writer.Written() {
+			app.logError(c.Request, err)
+			return
+		}
+		app.serverErrorResponse(c, err)
+	}
+}
+
+func (app *application) showUserHandler(c *gin.Context) {
+	user := app.adminTargetUser(c)
+	if user == nil {
+		return
+	}
+
+	permissions, err := app.models.PermissionModel.Permissions.GetAllForUser(c.Request.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	activeSessions, err := app.models.TokenModel.Tokens.CountForUser(c.Request.Context(), data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = app.writeJSON(c, http.StatusOK, envelope{
+		"user":            user,
+		"permissions":     permissions,
+		"active_sessions": activeSessions,
+	})
+	if err != nil {
+		app.serverErrorResponse(c, err)
+	}
+}
+
+func (app *application) updateUserActivationHandler(c *gin.Context) {
+	user := app.adminTargetUser(c)
+	if user == nil {
+		return
+	}
+
+	var input struct {
+		Activated *bool `json:"activated"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Activated != nil, "activated", "required")
+	if input.Activated != nil && !*input.Activated {
+		actor := c.Value("user").(*data.User)
+		v.Check(actor.ID != user.ID, "activated", "cannot_deactivate_self")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	user.Activated = *input.Activated
+
+	err = app.models.UserModel.Users.Update(c.Request.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+
+		return
+	}
+
+	action := "users.activate"
+	if !user.Activated {
+		action = "users.deactivate"
+
+		// a deactivated account shouldn't keep its existing sessions
+		err = app.models.TokenModel.Tokens.DeleteAllForUser(c.Request.Context(), data.ScopeAuthentication, user.ID)
+		if err != nil {
+			app.serverErrorResponse(c, err)
+			return
+		}
+	}
+
+	app.recordAudit(c, action, user.ID, nil)
+
+	err = app.writeJSON(c, http.StatusOK, envelope{"user": user})
+	if err != nil {
+		app.serverErrorResponse(c, err)
+	}
+}
+
+func (app *application) forceUserPasswordResetHandler(c *gin.Context) {
+	user := app.adminTargetUser(c)
+	if user == nil {
+		return
+	}
+
+	// replace the password with a random one nobody knows, so the only way
+	// back into the account is through the reset token we mail out
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = user.Set(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)[:48])
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = app.models.UserModel.Users.Update(c.Request.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+
+		return
+	}
+
+	err = app.models.TokenModel.Tokens.DeleteAllForUser(c.Request.Context(), data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	token, err := app.models.TokenModel.Tokens.New(c.Request.Context(), user.ID, 45*time.Minute, data.ScopePasswordRest)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.background(c, "forced password reset email", func(ctx context.Context) {
+		data := map[string]interface{}{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(ctx, user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			app.logBackgroundError(ctx, err)
+		}
+	})
+
+	app.recordAudit(c, "users.force_password_reset", user.ID, nil)
+
+	err = app.writeJSON(c, http.StatusAccepted, envelope{
+		"message": "the user's password has been reset and an email will be sent containing reset instructions",
+	})
+	if err != nil {
+		app.serverErrorResponse(c, err)
+	}
+}
+
+func (app *application) revokeUserTokensHandler(c *gin.Context) {
+	user := app.adminTargetUser(c)
+	if user == nil {
+		return
+	}
+
+	err := app.models.TokenModel.Tokens.DeleteAllScopesForUser(c.Request.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.recordAudit(c, "users.revoke_tokens", user.ID, nil)
+
+	err = app.writeJSON(c, http.StatusOK, envelope{"message": "all tokens for the user were successfully revoked"})
+	if err != nil {
+		app.serverErrorResponse(c, err)
+	}
+}
+
+func (app *application) deleteUserHandler(c *gin.Context) {
+	user := app.adminTargetUser(c)
+	if user == nil {
+		return
+	}
+
+	actor := c.Value("user").(*data.User)
+	if actor.ID == user.ID {
+		v := validator.New()
+		v.AddError("id", "cannot_delete_self")
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	err := app.models.UserModel.Users.Delete(c.Request.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.recordAudit(c, "users.delete", user.ID, map[string]string{
+		"email": user.Email,
+	})
+
+	err = app.writeJSON(c, http.StatusOK, envelope{"message": "user successfully deleted"})
+	if err != nil {
+		app.serverErrorResponse(c, err)
+	}
+}
+
+// checkAuthorizationHandler evaluates a request against the policy without
+// performing it, returning the trace of every rule to explain the outcome
+func (app *application) checkAuthorizationHandler(c *gin.Context) {
+	var input struct {
+		UserID   int64          `json:"user_id"`
+		Action   string         `json:"action"`
+		Method   string         `json:"method"`
+		Path     string         `json:"path"`
+		Resource authz.Resource `json:"resource"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.UserID >= 0, "user_id", "not_negative")
+	v.Check(input.Action != "" || (input.Method != "" && input.Path != ""), "action", "action_or_route_required")
+
+	if !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	// resolve the action from the route table when a route was given
+	if input.Action == "" {
+		route, found := app.authz.Route(input.Method, input.Path)
+		if !found || route.Public {
+			decision := authz.Decision{
+				Allowed: found,
+				Reason:  "route is not covered by the policy and is denied",
+				Trace:   []authz.RuleResult{},
+			}
+			if found {
+				decision.Reason = "route is public"
+			}
+
+			err = app.writeJSON(c, http.StatusOK, envelope{"decision": decision})
+			if err != nil {
+				app.serverErrorResponse(c, err)
+			}
+			return
+		}
+
+		input.Action = route.Action
+		if input.Resource.Type == "" {
+			input.Resource.Type = route.Resource
+		}
+	}
+
+	// an empty user_id checks the request as an anonymous user
+	subject := authz.Subject{Anonymous: true}
+	if input.UserID > 0 {
+		user, err := app.models.UserModel.Users.Get(c.Request.Context(), input.UserID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("user_id", "user_not_found")
+				app.failedValidationResponse(c, v.Errors)
+			default:
+				app.serverErrorResponse(c, err)
+			}
+			return
+		}
+
+		permissions, err := app.models.PermissionModel.Permissions.GetAllForUser(c.Request.Context(), user.ID)
+		if err != nil {
+			app.serverErrorResponse(c, err)
+			return
+		}
+
+		subject = authz.Subject{
+			ID:          user.ID,
+			Activated:   user.Activated,
+			Permissions: permissions,
+		}
+	}
+
+	req := authz.Request{
+		Subject:  subject,
+		Action:   input.Action,
+		Resource: input.Resource,
+	}
+
+	err = app.writeJSON(c, http.StatusOK, envelope{
+		"request":  req,
+		"decision": app.authz.Evaluate(req),
+	})
+	if err != nil {
+		app.serverErrorResponse(c, err)
+	}
+}
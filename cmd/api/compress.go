@@ -0,0 +1,276 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encoders we can produce, in the order we prefer them when a client accepts
+// several with the same quality
+var encodings = []string{"br", "zstd", "gzip"}
+
+var encoderPools = map[string]*sync.Pool{
+	"gzip": {New: func() any {
+		w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+		return w
+	}},
+	"br": {New: func() any {
+		return brotli.NewWriterLevel(io.Discard, 4)
+	}},
+	"zstd": {New: func() any {
+		w, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return w
+	}},
+}
+
+type encoder interface {
+	io.WriteCloser
+	Reset(io.Writer)
+	Flush() error
+}
+
+// incompressibleTypes are media types that are already compressed, or are
+// streamed and must reach the client as soon as they are written
+var incompressibleTypes = []string{
+	"image/", "video/", "audio/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+	"application/octet-stream", "text/event-stream",
+}
+
+// compress negotiates a Content-Encoding with the client and compresses
+// responses of at least the configured minimum size
+func (app *application) compress() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !app.config.Compression.Enable {
+			ctx.Next()
+			return
+		}
+
+		ctx.Writer.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(ctx.Request.Header.Get("Accept-Encoding"))
+		if encoding == "" || ctx.Request.Method == http.MethodHead {
+			ctx.Next()
+			return
+		}
+
+		w := &compressWriter{
+			ResponseWriter: ctx.Writer,
+			encoding:       encoding,
+			minSize:        app.config.Compression.MinSize,
+			status:         http.StatusOK,
+		}
+		ctx.Writer = w
+
+		defer func() {
+			w.finish()
+			ctx.Writer = w.ResponseWriter
+		}()
+
+		ctx.Next()
+	}
+}
+
+// negotiateEncoding picks the encoding with the highest quality in an
+// Accept-Encoding header, returning "" when the response should stay identity
+func negotiateEncoding(header string) string {
+	if header == "" {
+		return ""
+	}
+
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		qualities[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range encodings {
+		q, found := qualities[encoding]
+		if !found {
+			q, found = qualities["*"]
+		}
+
+		if found && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	return best
+}
+
+// compressWriter holds the response back until minSize bytes were written, so
+// it can tell whether compressing is worth it, and then either compresses the
+// rest or passes everything through untouched
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	started bool
+	decided bool
+	encoder encoder
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if !w.decided && code > 0 {
+		w.status = code
+	}
+}
+
+func (w *compressWriter) WriteHeaderNow() {
+	w.started = true
+	if !w.decided {
+		w.passthrough()
+	}
+}
+
+func (w *compressWriter) Status() int {
+	if !w.decided {
+		return w.status
+	}
+
+	return w.ResponseWriter.Status()
+}
+
+func (w *compressWriter) Written() bool {
+	return w.started || w.ResponseWriter.Written()
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	w.started = true
+
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.minSize {
+			return len(p), nil
+		}
+
+		w.decide()
+		return len(p), w.flushBuffer()
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(p)
+	}
+
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush means the handler is streaming, anything not decided yet goes out as is
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.passthrough()
+		_ = w.flushBuffer()
+	}
+
+	if w.encoder != nil {
+		_ = w.encoder.Flush()
+	}
+
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) decide() {
+	header := w.Header()
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+
+	switch {
+	case header.Get("Content-Encoding") != "",
+		w.status < http.StatusOK,
+		w.status == http.StatusNoContent,
+		w.status == http.StatusNotModified,
+		!compressible(mediaType):
+		w.passthrough()
+		return
+	}
+
+	w.decided = true
+	header.Set("Content-Encoding", w.encoding)
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+	w.ResponseWriter.WriteHeader(w.status)
+
+	w.encoder = encoderPools[w.encoding].Get().(encoder)
+	w.encoder.Reset(w.ResponseWriter)
+}
+
+func (w *compressWriter) passthrough() {
+	w.decided = true
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *compressWriter) flushBuffer() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+
+	w.buf = nil
+	return err
+}
+
+// finish sends whatever is still held back, small responses go out uncompressed
+func (w *compressWriter) finish() {
+	if !w.decided {
+		if !w.started {
+			// the handler wrote nothing, leave the response to gin
+			w.ResponseWriter.WriteHeader(w.status)
+			return
+		}
+
+		w.passthrough()
+	}
+
+	_ = w.flushBuffer()
+
+	if w.encoder != nil {
+		_ = w.encoder.Close()
+		encoderPools[w.encoding].Put(w.encoder)
+		w.encoder = nil
+	}
+}
+
+func compressible(mediaType string) bool {
+	if mediaType == "image/svg+xml" {
+		return true
+	}
+
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
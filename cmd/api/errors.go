@@ -1,86 +1,255 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"greenlight.fyerfyer.net/internal/authz"
+	"greenlight.fyerfyer.net/internal/i18n"
+	"greenlight.fyerfyer.net/internal/metrics"
+	"greenlight.fyerfyer.net/internal/requestid"
+	"greenlight.fyerfyer.net/internal/validator"
 )
 
 func (app *application) logError(r *http.Request, err error) {
 	app.logger.PrintError(err, map[string]string{
+		"request_id":     requestid.FromContext(r.Context()),
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
+		"client_ip":      app.realip.ClientIP(r),
 	})
 }
 
-func (app *application) errorResponse(c *gin.Context, status int, message interface{}) {
-	env := envelope{"error": message}
-	jsonData, err := formatJSONWithNewline(env)
+// logBackgroundError logs an error from a background task, tagged with the
+// ID of the request that started it
+func (app *application) logBackgroundError(ctx context.Context, err error) {
+	app.logger.PrintError(err, map[string]string{
+		"request_id": requestid.FromContext(ctx),
+	})
+}
+
+const (
+	problemContentType = "application/problem+json"
+
+	// problemTypeBase prefixes the error code to build the RFC 9457 problem type URI
+	problemTypeBase = "https://greenlight.fyerfyer.net/problems/"
+)
+
+// language picks the language for error messages from the Accept-Language header
+func (app *application) language(c *gin.Context) string {
+	return i18n.Negotiate(c.GetHeader("Accept-Language"))
+}
+
+// errorResponse writes the error in the format the client asked for: clients
+// accepting application/problem+json get an RFC 9457 problem document, everyone
+// else the {"error": ...} envelope. code is a stable machine-readable identifier
+// and message either a localized string or the field errors of a failed validation
+func (app *application) errorResponse(c *gin.Context, status int, code string, message interface{}) {
+	id := requestid.FromContext(c.Request.Context())
+	lang := app.language(c)
+
+	c.Header("Content-Language", lang)
+	c.Writer.Header().Add("Vary", "Accept-Language")
+
+	var err error
+	switch c.NegotiateFormat("application/json", problemContentType) {
+	case problemContentType:
+		err = app.writeProblem(c, status, code, id, lang, message)
+	default:
+		if fields, ok := message.(map[string]validator.Error); ok {
+			translated := make(map[string]string, len(fields))
+			for field, fieldErr := range fields {
+				translated[field] = i18n.T(lang, "validation."+fieldErr.Code, fieldErr.Args...)
+			}
+			message = translated
+		}
+
+		env := envelope{"error": message, "code": code}
+		if id != "" {
+			env["request_id"] = id
+		}
+		err = app.writeJSON(c, status, env)
+	}
+
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error encoding JSON"})
-		return
+		app.logError(c.Request, err)
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+type problemFieldError struct {
+	Field  string `json:"field"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+func (app *application) writeProblem(c *gin.Context, status int, code, id, lang string, message interface{}) error {
+	problem := envelope{
+		"type":     problemTypeBase + code,
+		"title":    http.StatusText(status),
+		"status":   status,
+		"instance": c.Request.URL.Path,
+		"code":     code,
+	}
+
+	if id != "" {
+		problem["request_id"] = id
+	}
+
+	switch message := message.(type) {
+	case map[string]validator.Error:
+		problem["detail"] = i18n.T(lang, "error.validation_failed")
+
+		fields := make([]problemFieldError, 0, len(message))
+		for field, fieldErr := range message {
+			fields = append(fields, problemFieldError{
+				Field:  field,
+				Code:   fieldErr.Code,
+				Detail: i18n.T(lang, "validation."+fieldErr.Code, fieldErr.Args...),
+			})
+		}
+		sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+		problem["errors"] = fields
+	default:
+		problem["detail"] = fmt.Sprint(message)
+	}
+
+	js, err := marshalJSON(c, problem)
+	if err != nil {
+		return err
 	}
 
-	c.String(status, jsonData)
+	c.Data(status, problemContentType, js)
+	return nil
 }
 
 func (app *application) serverErrorResponse(c *gin.Context, err error) {
+	// errors caused by the request running out of time aren't server faults
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(c.Request.Context().Err(), context.DeadlineExceeded):
+		app.timeoutResponse(c, err)
+		return
+	case errors.Is(err, context.Canceled), errors.Is(c.Request.Context().Err(), context.Canceled):
+		app.requestCancelledResponse(c)
+		return
+	}
+
+	app.logError(c.Request, err)
+	message := i18n.T(app.language(c), "error.server_error")
+	app.errorResponse(c, http.StatusInternalServerError, "server_error", message)
+}
+
+func (app *application) timeoutResponse(c *gin.Context, err error) {
 	app.logError(c.Request, err)
-	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(c, http.StatusInternalServerError, message)
+	message := i18n.T(app.language(c), "error.timeout")
+	app.errorResponse(c, http.StatusGatewayTimeout, "timeout", message)
+}
+
+// requestCancelledResponse answers a request the client gave up on, mostly so
+// the access log and metrics record it
+func (app *application) requestCancelledResponse(c *gin.Context) {
+	message := i18n.T(app.language(c), "error.request_cancelled")
+	app.errorResponse(c, http.StatusServiceUnavailable, "request_cancelled", message)
+}
+
+// unavailableResponse rejects a request the current service mode doesn't accept
+func (app *application) unavailableResponse(c *gin.Context, mode *serviceMode) {
+	if mode.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(max(1, ceilSeconds(mode.RetryAfter))))
+	}
+
+	message := i18n.T(app.language(c), "error."+mode.Mode)
+	app.errorResponse(c, http.StatusServiceUnavailable, mode.Mode, message)
 }
 
 func (app *application) notFoundResponse(c *gin.Context) {
-	message := "the requested resource could not be found"
-	app.errorResponse(c, http.StatusNotFound, message)
+	message := i18n.T(app.language(c), "error.not_found")
+	app.errorResponse(c, http.StatusNotFound, "not_found", message)
 }
 
 func (app *application) methodNotAllowedResponse(c *gin.Context) {
-	message := fmt.Sprintf("the %s method is not supported for this resource", c.Request.Method)
-	app.errorResponse(c, http.StatusMethodNotAllowed, message)
+	message := i18n.T(app.language(c), "error.method_not_allowed", c.Request.Method)
+	app.errorResponse(c, http.StatusMethodNotAllowed, "method_not_allowed", message)
 }
 
 func (app *application) badRequestResponse(c *gin.Context, err error) {
-	app.errorResponse(c, http.StatusBadRequest, err.Error())
+	message := err.Error()
+
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		message = i18n.T(app.language(c), "request."+reqErr.code, reqErr.args...)
+	}
+
+	app.errorResponse(c, http.StatusBadRequest, "bad_request", message)
 }
 
-func (app *application) failedValidationResponse(c *gin.Context, errors map[string]string) {
-	app.errorResponse(c, http.StatusUnprocessableEntity, errors)
+func (app *application) failedValidationResponse(c *gin.Context, errors map[string]validator.Error) {
+	app.errorResponse(c, http.StatusUnprocessableEntity, "validation_failed", errors)
 }
 
 func (app *application) editConflictResponse(c *gin.Context) {
-	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(c, http.StatusConflict, message)
+	message := i18n.T(app.language(c), "error.edit_conflict")
+	app.errorResponse(c, http.StatusConflict, "edit_conflict", message)
+}
+
+func (app *application) idempotencyKeyReusedResponse(c *gin.Context) {
+	message := i18n.T(app.language(c), "error.idempotency_key_reused")
+	app.errorResponse(c, http.StatusUnprocessableEntity, "idempotency_key_reused", message)
+}
+
+func (app *application) idempotencyKeyInProgressResponse(c *gin.Context) {
+	message := i18n.T(app.language(c), "error.idempotency_key_in_progress")
+	app.errorResponse(c, http.StatusConflict, "idempotency_key_in_progress", message)
 }
 
 func (app *application) rateLimitExceededResponse(c *gin.Context) {
-	message := "rate limit exceeded"
-	app.errorResponse(c, http.StatusTooManyRequests, message)
+	message := i18n.T(app.language(c), "error.rate_limited")
+	app.errorResponse(c, http.StatusTooManyRequests, "rate_limited", message)
 }
 
 func (app *application) invalidCredentialsResponse(c *gin.Context) {
-	message := "invalid authentication credentials"
-	app.errorResponse(c, http.StatusUnauthorized, message)
+	metrics.AuthFailures.WithLabelValues("invalid_credentials").Inc()
+	message := i18n.T(app.language(c), "error.invalid_credentials")
+	app.errorResponse(c, http.StatusUnauthorized, "invalid_credentials", message)
 }
 
 func (app *application) invalidAuthenticationTokenResponse(c *gin.Context) {
+	metrics.AuthFailures.WithLabelValues("invalid_token").Inc()
 	c.Writer.Header().Set("WWW-Authenticate", "Bearer")
-	message := "invalid or missing authentication token"
-	app.errorResponse(c, http.StatusUnauthorized, message)
+	message := i18n.T(app.language(c), "error.invalid_token")
+	app.errorResponse(c, http.StatusUnauthorized, "invalid_token", message)
 }
 
 func (app *application) authenticationRequiredResponse(c *gin.Context) {
-	message := "you must be authenticated to access this resource"
-	app.errorResponse(c, http.StatusUnauthorized, message)
+	metrics.AuthFailures.WithLabelValues("authentication_required").Inc()
+	message := i18n.T(app.language(c), "error.authentication_required")
+	app.errorResponse(c, http.StatusUnauthorized, "authentication_required", message)
 }
 
 func (app *application) inactiveAccountResponse(c *gin.Context) {
-	message := "your user account must be activated to access this resource"
-	app.errorResponse(c, http.StatusForbidden, message)
+	metrics.AuthFailures.WithLabelValues("inactive_account").Inc()
+	message := i18n.T(app.language(c), "error.inactive_account")
+	app.errorResponse(c, http.StatusForbidden, "inactive_account", message)
 }
 
 func (app *application) notPermittedResponse(c *gin.Context) {
-	message := "your user account doesn't have the necessary permission to access this resource"
-	app.errorResponse(c, http.StatusForbidden, message)
+	metrics.AuthFailures.WithLabelValues("not_permitted").Inc()
+	message := i18n.T(app.language(c), "error.not_permitted")
+	app.errorResponse(c, http.StatusForbidden, "not_permitted", message)
+}
+
+func (app *application) accessDeniedResponse(c *gin.Context, subject authz.Subject) {
+	switch {
+	case subject.Anonymous:
+		app.authenticationRequiredResponse(c)
+	case !subject.Activated:
+		app.inactiveAccountResponse(c)
+	default:
+		app.notPermittedResponse(c)
+	}
 }
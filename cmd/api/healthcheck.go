@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
-	// "time"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"greenlight.fyerfyer.net/internal/data"
 )
 
 func (app *application) healthcheckHandler(c *gin.Context) {
 	env := envelope{
 		"status": "available",
+		"mode":   app.mode.Get().Mode,
 		"system_info": map[string]string{
 			"environment": app.config.Env,
 			"version":     app.config.Version,
@@ -18,11 +22,79 @@ func (app *application) healthcheckHandler(c *gin.Context) {
 
 	// time.Sleep(4 * time.Second)
 
-	jsonData, err := formatJSONWithNewline(env)
+	err := app.writeJSON(c, http.StatusOK, env)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error encoding JSON"})
-		return
+		app.serverErrorResponse(c, err)
 	}
+}
+
+// livezHandler only tells the orchestrator the process is up and serving,
+// dependencies are left to readyz so a database outage doesn't get us restarted
+func (app *application) livezHandler(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	err := app.writeJSON(c, http.StatusOK, envelope{"status": "alive"})
+	if err != nil {
+		app.serverErrorResponse(c, err)
+	}
+}
+
+type readinessCheck struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// readyzHandler checks everything the API needs to serve traffic, answering
+// 503 with the result of each check when any of them fails. Errors can name
+// internal hosts, so the public listener only says which checks failed
+func (app *application) readyzHandler(c *gin.Context) {
+	app.readiness(c, false)
+}
+
+// adminReadyzHandler is readyzHandler on the admin listener, with the errors
+func (app *application) adminReadyzHandler(c *gin.Context) {
+	app.readiness(c, true)
+}
+
+func (app *application) readiness(c *gin.Context, detailed bool) {
+	checks := map[string]func(ctx context.Context) error{
+		"database":   data.Ping,
+		"migrations": data.CheckMigrations,
+		"shutdown": func(ctx context.Context) error {
+			if app.shuttingDown.Load() {
+				return errors.New("server is shutting down")
+			}
+			return nil
+		},
+	}
+	if app.config.Health.CheckSMTP {
+		checks["smtp"] = app.mailer.Ping
+	}
+
+	status, ready := http.StatusOK, "ready"
+	results := make(map[string]readinessCheck, len(checks))
+	for name, check := range checks {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), app.config.Health.CheckTimeout)
+		start := time.Now()
+		err := check(ctx)
+		cancel()
 
-	c.String(http.StatusOK, jsonData)
+		result := readinessCheck{Status: "ok", Duration: time.Since(start).String()}
+		if err != nil {
+			result.Status = "failed"
+			if detailed {
+				result.Error = err.Error()
+			}
+			status, ready = http.StatusServiceUnavailable, "unavailable"
+		}
+		results[name] = result
+	}
+
+	c.Header("Cache-Control", "no-store")
+
+	err := app.writeJSON(c, status, envelope{"status": ready, "checks": results})
+	if err != nil {
+		app.serverErrorResponse(c, err)
+	}
 }
@@ -1,21 +1,41 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"greenlight.fyerfyer.net/internal/i18n"
+	"greenlight.fyerfyer.net/internal/requestid"
+	"greenlight.fyerfyer.net/internal/tracing"
 	"greenlight.fyerfyer.net/internal/validator"
 )
 
 type envelope map[string]interface{}
 
+// requestError is a malformed request body, identified by a message code so
+// badRequestResponse can render it in the client's language
+type requestError struct {
+	code string
+	args []any
+}
+
+func (e *requestError) Error() string {
+	return i18n.T(i18n.DefaultLanguage, "request."+e.code, e.args...)
+}
+
 func (app *application) readJSON(c *gin.Context, dst any) error {
 	maxBytes := 1_048_576
 	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(maxBytes))
@@ -27,26 +47,30 @@ func (app *application) readJSON(c *gin.Context, dst any) error {
 		var syntaxError *json.SyntaxError
 		var unmarshalTypeError *json.UnmarshalTypeError
 		var invalidUnmarshalError *json.InvalidUnmarshalError
+		var maxBytesError *http.MaxBytesError
 
 		switch {
 		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+			return &requestError{"badly_formed_json_at", []any{syntaxError.Offset}}
 
 		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
+			return &requestError{code: "badly_formed_json"}
 
 		case errors.As(err, &unmarshalTypeError):
 			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+				return &requestError{"incorrect_type_for_field", []any{unmarshalTypeError.Field}}
 			}
-			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+			return &requestError{"incorrect_type_at", []any{unmarshalTypeError.Offset}}
 
 		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
+			return &requestError{code: "empty_body"}
 
 		case strings.HasPrefix(err.Error(), "json: unknown field "):
 			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
+			return &requestError{"unknown_key", []any{fieldName}}
+
+		case errors.As(err, &maxBytesError):
+			return &requestError{"body_too_large", []any{maxBytesError.Limit}}
 
 		case errors.As(err, &invalidUnmarshalError):
 			panic(err)
@@ -57,31 +81,120 @@ func (app *application) readJSON(c *gin.Context, dst any) error {
 	}
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
-		return errors.New("body must only contain a single JSON value")
+		return &requestError{code: "multiple_values"}
 	}
 
 	return nil
 }
 
-func formatJSONWithNewline(data interface{}) (string, error) {
-	jsonData, err := json.MarshalIndent(data, "", "  ")
+const jsonContentType = "application/json; charset=utf-8"
+
+// prettyJSON reports whether the client asked for indented output with ?pretty=true
+func prettyJSON(c *gin.Context) bool {
+	pretty, _ := strconv.ParseBool(c.Query("pretty"))
+	return pretty
+}
+
+// marshalJSON encodes data compactly, or indented when the client asked for
+// it, always followed by a newline to be friendlier to terminal clients
+func marshalJSON(c *gin.Context, data interface{}) ([]byte, error) {
+	var js []byte
+	var err error
+	if prettyJSON(c) {
+		js, err = json.MarshalIndent(data, "", "  ")
+	} else {
+		js, err = json.Marshal(data)
+	}
+
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return string(jsonData) + "\n", nil
+	return append(js, '\n'), nil
 }
 
 func (app *application) writeJSON(c *gin.Context, code int, data map[string]interface{}) error {
-	formattedJSON, err := formatJSONWithNewline(data)
+	js, err := marshalJSON(c, data)
 	if err != nil {
 		return err
 	}
 
-	c.String(code, formattedJSON)
+	c.Data(code, jsonContentType, js)
 	return nil
 }
 
+// writeJSONList writes {"<key>": [items...], ...extra} encoding one item at a
+// time, so a large page is never held in memory twice. Once the first byte is
+// out the status can't change anymore, so errors past that point are only returned
+func writeJSONList[T any](c *gin.Context, code int, key string, items []T, extra envelope) error {
+	// an empty list is [] rather than null whichever way it is formatted
+	if items == nil {
+		items = []T{}
+	}
+
+	if prettyJSON(c) {
+		env := envelope{key: items}
+		for k, v := range extra {
+			env[k] = v
+		}
+
+		js, err := json.MarshalIndent(env, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		c.Data(code, jsonContentType, append(js, '\n'))
+		return nil
+	}
+
+	// encode the small fields up front, so a bad value fails before anything is written
+	fields := make([]byte, 0, 128)
+	keys := make([]string, 0, len(extra))
+	for k := range extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		name, _ := json.Marshal(k)
+		value, err := json.Marshal(extra[k])
+		if err != nil {
+			return err
+		}
+
+		fields = append(fields, name...)
+		fields = append(fields, ':')
+		fields = append(fields, value...)
+		fields = append(fields, ',')
+	}
+
+	c.Header("Content-Type", jsonContentType)
+	c.Status(code)
+
+	w := bufio.NewWriter(c.Writer)
+	name, _ := json.Marshal(key)
+
+	w.WriteByte('{')
+	w.Write(fields)
+	w.Write(name)
+	w.WriteString(":[")
+
+	for i, item := range items {
+		js, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.Write(js)
+	}
+
+	w.WriteString("]}\n")
+	return w.Flush()
+}
+
 func (app *application) readString(value url.Values, key string, defaultValue string) string {
 	s := value.Get(key)
 	if s == "" {
@@ -107,22 +220,49 @@ func (app *application) readInt(value url.Values, key string, defaultValue int,
 
 	i, err := strconv.Atoi(s)
 	if err != nil {
-		v.AddError(key, "must be an integer value")
+		v.AddError(key, "integer")
 		return defaultValue
 	}
 
 	return i
 }
 
-func (app *application) background(fn func()) {
+// clientIP returns the address resolveClientIP settled on for the request
+func (app *application) clientIP(c *gin.Context) string {
+	if ip := c.GetString("client_ip"); ip != "" {
+		return ip
+	}
+
+	return app.realip.ClientIP(c.Request)
+}
+
+// background runs fn in a goroutine tracked by app.wg and app.tasks, name
+// describes the task in the shutdown log if it is still running then. The
+// context handed to fn keeps the values of the request, such as its ID, but
+// isn't cancelled with it, it has its own -background-timeout deadline instead
+func (app *application) background(c *gin.Context, name string, fn func(ctx context.Context)) {
+	ctx := context.WithoutCancel(c.Request.Context())
+
 	app.wg.Add(1)
+	id := app.tasks.start(name, requestid.FromContext(ctx))
 	go func() {
 		defer app.wg.Done()
+		defer app.tasks.finish(id)
+
+		ctx, cancel := context.WithTimeout(ctx, app.config.Timeouts.Background)
+		defer cancel()
+
+		ctx, span := tracing.Tracer().Start(ctx, "background task", trace.WithAttributes(
+			attribute.String("task.name", name),
+		))
+		defer span.End()
+
 		defer func() {
 			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+				span.SetStatus(codes.Error, fmt.Sprint(err))
+				app.logBackgroundError(ctx, fmt.Errorf("%s", err))
 			}
 		}()
-		fn()
+		fn(ctx)
 	}()
 }
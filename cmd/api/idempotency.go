@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"greenlight.fyerfyer.net/internal/data"
+)
+
+const (
+	maxIdempotencyKeyLength = 255
+	// a retry racing the request holding its key waits this long for the
+	// response, checking every idempotencyPoll
+	idempotencyWait = 5 * time.Second
+	idempotencyPoll = 100 * time.Millisecond
+)
+
+// replayedHeaders are the response headers stored with an idempotent response
+// and sent again when it is replayed
+var replayedHeaders = []string{"Content-Type", "Location"}
+
+// idempotent makes a POST safe to retry: the first request sent with an
+// Idempotency-Key header is processed and its response stored, retries with the
+// same key and body get that response replayed, and reusing the key for a
+// different body is rejected. Keys are scoped to the user, and anonymous
+// callers, who all share user 0, to their IP as well
+func (app *application) idempotent() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader("Idempotency-Key")
+		if key == "" {
+			ctx.Next()
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			app.badRequestResponse(ctx, &requestError{"idempotency_key_too_long", []any{maxIdempotencyKeyLength}})
+			ctx.Abort()
+			return
+		}
+
+		// the handler still decodes the body, so read it up front and put it back
+		maxBytes := int64(1_048_576)
+		body, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxBytes))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				err = &requestError{"body_too_large", []any{maxBytesError.Limit}}
+			}
+			app.badRequestResponse(ctx, err)
+			ctx.Abort()
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		user := ctx.Value("user").(*data.User)
+		scope := ctx.Request.Method + " " + ctx.Request.URL.Path
+		if user.IsAnonymous() {
+			scope += " " + app.clientIP(ctx)
+		}
+
+		hash := sha256.New()
+		hash.Write([]byte(scope + "\n"))
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		record, err := app.models.IdempotencyKeyModel.IdempotencyKeys.Get(ctx.Request.Context(), key, user.ID, scope)
+		switch {
+		case err == nil:
+			app.replayIdempotent(ctx, record, fingerprint)
+			ctx.Abort()
+			return
+		case !errors.Is(err, data.ErrRecordNotFound):
+			app.serverErrorResponse(ctx, err)
+			ctx.Abort()
+			return
+		}
+
+		record = &data.IdempotencyKey{
+			Key:         key,
+			UserID:      user.ID,
+			Scope:       scope,
+			Fingerprint: fingerprint,
+			ExpiresAt:   time.Now().Add(app.config.Idempotency.TTL),
+		}
+
+		err = app.models.IdempotencyKeyModel.IdempotencyKeys.Insert(ctx.Request.Context(), record)
+		if err != nil {
+			if errors.Is(err, data.ErrDuplicateIdempotencyKey) {
+				// a concurrent retry got the key first, answer with its response
+				err = app.replayConcurrent(ctx, key, user.ID, scope, fingerprint)
+			}
+			if err != nil {
+				app.serverErrorResponse(ctx, err)
+			}
+			ctx.Abort()
+			return
+		}
+
+		w := &captureWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = w
+
+		// the key is settled even if the client went away in the meantime
+		settleCtx := context.WithoutCancel(ctx.Request.Context())
+
+		completed := false
+		defer func() {
+			ctx.Writer = w.ResponseWriter
+
+			// a panic or a server error releases the key so the client can retry
+			if completed {
+				return
+			}
+			if err := app.models.IdempotencyKeyModel.IdempotencyKeys.Delete(settleCtx, record); err != nil {
+				app.logError(ctx.Request, err)
+			}
+		}()
+
+		ctx.Next()
+
+		if w.Status() >= http.StatusInternalServerError {
+			return
+		}
+
+		record.Status = w.Status()
+		record.Body = w.body.Bytes()
+		record.Headers = make(map[string]string)
+		for _, name := range replayedHeaders {
+			if value := w.Header().Get(name); value != "" {
+				record.Headers[name] = value
+			}
+		}
+
+		if err := app.models.IdempotencyKeyModel.IdempotencyKeys.Complete(settleCtx, record); err != nil {
+			app.logError(ctx.Request, err)
+			return
+		}
+		completed = true
+	}
+}
+
+// replayConcurrent answers a request that lost the race for its key to a
+// concurrent one, replaying that request's response
+func (app *application) replayConcurrent(ctx *gin.Context, key string, userID int64, scope, fingerprint string) error {
+	record, err := app.models.IdempotencyKeyModel.IdempotencyKeys.Get(ctx.Request.Context(), key, userID, scope)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		// the other request failed and released the key in the meantime
+		app.idempotencyKeyInProgressResponse(ctx)
+		return nil
+	case err != nil:
+		return err
+	}
+
+	app.replayIdempotent(ctx, record, fingerprint)
+	return nil
+}
+
+// replayIdempotent answers a retry from the stored response, waiting for it
+// while the request holding the key is still being processed
+func (app *application) replayIdempotent(ctx *gin.Context, record *data.IdempotencyKey, fingerprint string) {
+	if record.Fingerprint != fingerprint {
+		app.idempotencyKeyReusedResponse(ctx)
+		return
+	}
+
+	if record.Status == 0 {
+		completed, err := app.awaitIdempotent(ctx, record)
+		if err != nil {
+			app.serverErrorResponse(ctx, err)
+			return
+		}
+		if completed == nil {
+			app.idempotencyKeyInProgressResponse(ctx)
+			return
+		}
+		record = completed
+	}
+
+	for name, value := range record.Headers {
+		ctx.Header(name, value)
+	}
+	ctx.Header("Idempotent-Replayed", "true")
+	ctx.Data(record.Status, record.Headers["Content-Type"], record.Body)
+}
+
+// awaitIdempotent polls the key until the request holding it completes, it
+// returns nil when that takes longer than idempotencyWait or the request failed
+// and released the key, the client is then told to retry
+func (app *application) awaitIdempotent(ctx *gin.Context, record *data.IdempotencyKey) (*data.IdempotencyKey, error) {
+	timeout := time.NewTimer(idempotencyWait)
+	defer timeout.Stop()
+	ticker := time.NewTicker(idempotencyPoll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Request.Context().Done():
+			return nil, ctx.Request.Context().Err()
+		case <-timeout.C:
+			return nil, nil
+		case <-ticker.C:
+		}
+
+		current, err := app.models.IdempotencyKeyModel.IdempotencyKeys.Get(ctx.Request.Context(), record.Key, record.UserID, record.Scope)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, nil
+		case err != nil:
+			return nil, err
+		case current.Status != 0:
+			return current, nil
+		}
+	}
+}
+
+// captureWriter keeps a copy of the response body as it is written
+type captureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -2,12 +2,23 @@ package main
 
 import (
 	// "expvar"
+	"context"
+	"fmt"
+	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"greenlight.fyerfyer.net/internal/authz"
 	"greenlight.fyerfyer.net/internal/config"
+	"greenlight.fyerfyer.net/internal/cors"
 	"greenlight.fyerfyer.net/internal/data"
 	"greenlight.fyerfyer.net/internal/jsonlog"
 	"greenlight.fyerfyer.net/internal/mailer"
+	"greenlight.fyerfyer.net/internal/metrics"
+	"greenlight.fyerfyer.net/internal/ratelimit"
+	"greenlight.fyerfyer.net/internal/realip"
+	"greenlight.fyerfyer.net/internal/tracing"
 	// "gorm.io/driver/postgres"
 	// "gorm.io/gorm"
 	// "gorm.io/gorm/logger"
@@ -16,17 +27,39 @@ import (
 
 type application struct {
 	// version string
-	config config.Config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config      config.Config
+	logger      *jsonlog.Logger
+	models      data.Models
+	mailer      mailer.Mailer
+	authz       *authz.Engine
+	limiter     ratelimit.Limiter
+	limitPolicy *ratelimit.Policy
+	realip      *realip.Resolver
+	cors        *cors.Config
+	mode        modeSwitch
+	// shuttingDown is set once serve starts draining, failing readiness
+	shuttingDown atomic.Bool
+	// limiterFailing is set while the limiter backend errors, so the outage
+	// is logged when it starts and ends rather than on every request
+	limiterFailing atomic.Bool
+	wg             sync.WaitGroup
+	tasks          backgroundTasks
 }
 
 func main() {
-	config.InitConfig()
+	if err := config.InitConfig(); err != nil {
+		config.Logger.PrintFatal(err, nil)
+	}
 	config.ConfigExpvar()
 
+	if err := run(); err != nil {
+		config.Logger.PrintFatal(err, nil)
+	}
+}
+
+// run sets the application up and serves it, errors are returned rather than
+// fatal so the deferred cleanup, such as flushing spans, still happens
+func run() error {
 	app := &application{
 		config: config.Cfg,
 		logger: config.Logger,
@@ -38,14 +71,84 @@ func main() {
 			config.Cfg.Smtp.Sender),
 	}
 
-	err := data.InitSql()
+	shutdownTracing, err := tracing.Init(tracing.Options{
+		Exporter:    app.config.Tracing.Exporter,
+		Endpoint:    app.config.Tracing.OTLPEndpoint,
+		Insecure:    app.config.Tracing.OTLPInsecure,
+		SampleRatio: app.config.Tracing.SampleRatio,
+		ServiceName: "greenlight",
+		Version:     app.config.Version,
+		Env:         app.config.Env,
+	}, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	// flush the spans still buffered once the server has stopped
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := shutdownTracing(ctx); err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}()
+
+	app.authz, err = authz.Load(config.Cfg.Authz.PolicyFile)
 	if err != nil {
-		app.logger.PrintFatal(err, nil)
+		return err
 	}
 
+	app.realip, err = realip.New(app.config.TrustedProxies, app.config.ProxyHeader)
+	if err != nil {
+		return err
+	}
+
+	app.cors, err = cors.New(cors.Policy{
+		AllowedOrigins:   app.config.Cors.TrustedOrigins,
+		AllowedMethods:   app.config.Cors.AllowedMethods,
+		AllowedHeaders:   app.config.Cors.AllowedHeaders,
+		ExposedHeaders:   app.config.Cors.ExposedHeaders,
+		MaxAge:           app.config.Cors.MaxAge,
+		AllowCredentials: app.config.Cors.AllowCredentials,
+	}, app.config.Cors.OverridesFile)
+	if err != nil {
+		return err
+	}
+
+	app.limiter, err = newLimiter(app.config)
+	if err != nil {
+		return err
+	}
+
+	app.limitPolicy, err = ratelimit.LoadPolicy(app.config.Limiter.PolicyFile, ratelimit.Quota{
+		Rps:   app.config.Limiter.Rps,
+		Burst: app.config.Limiter.Burst,
+	})
+	if err != nil {
+		return err
+	}
+
+	app.mode.Set(app.config.Mode.Initial, app.config.Mode.RetryAfter)
+
+	err = data.InitSql()
+	if err != nil {
+		return err
+	}
+
+	metrics.RegisterDB(data.DB())
 	app.logger.PrintInfo("database connection pool established", nil)
 
-	if err := app.serve(); err != nil {
-		app.logger.PrintFatal(err, nil)
+	return app.serve()
+}
+
+func newLimiter(cfg config.Config) (ratelimit.Limiter, error) {
+	switch cfg.Limiter.Backend {
+	case "memory":
+		return ratelimit.NewMemory(), nil
+	case "redis":
+		return ratelimit.NewRedis(cfg.Limiter.RedisURL, cfg.Limiter.RedisPrefix)
+	default:
+		return nil, fmt.Errorf("unknown rate limiter backend %q", cfg.Limiter.Backend)
 	}
 }
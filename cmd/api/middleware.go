@@ -1,23 +1,77 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"expvar"
 	"fmt"
-	"log"
-	"net"
+	"math"
+	"math/rand/v2"
 	"net/http"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	// "github.com/tomasen/realip"
-	"golang.org/x/time/rate"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"greenlight.fyerfyer.net/internal/authz"
 	"greenlight.fyerfyer.net/internal/data"
+	"greenlight.fyerfyer.net/internal/metrics"
+	"greenlight.fyerfyer.net/internal/ratelimit"
+	"greenlight.fyerfyer.net/internal/requestid"
+	"greenlight.fyerfyer.net/internal/tracing"
 	"greenlight.fyerfyer.net/internal/validator"
 )
 
+// requestID tags the request with the X-Request-ID sent by the client, or a new
+// one when it is missing or unusable, and echoes it in the response
+func (app *application) requestID() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.Request.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+
+		ctx.Request = ctx.Request.WithContext(requestid.NewContext(ctx.Request.Context(), id))
+		ctx.Set("request_id", id)
+		ctx.Header(requestid.Header, id)
+		ctx.Next()
+	}
+}
+
+// hsts tells browsers to only use HTTPS from now on, sent over TLS only since
+// browsers ignore it on plain HTTP anyway
+func (app *application) hsts() gin.HandlerFunc {
+	value := fmt.Sprintf("max-age=%d", int(app.config.TLS.HSTS.MaxAge.Seconds()))
+	if app.config.TLS.HSTS.IncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if app.config.TLS.HSTS.Preload {
+		value += "; preload"
+	}
+
+	return func(ctx *gin.Context) {
+		if ctx.Request.TLS != nil && app.config.TLS.HSTS.MaxAge > 0 {
+			ctx.Header("Strict-Transport-Security", value)
+		}
+		ctx.Next()
+	}
+}
+
+// resolveClientIP works out the real client address once per request, so the
+// limiter, logs and sessions all agree on who the client is
+func (app *application) resolveClientIP() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set("client_ip", app.realip.ClientIP(ctx.Request))
+		ctx.Next()
+	}
+}
+
 func (app *application) recoverPanic() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		defer func() {
@@ -31,61 +85,126 @@ func (app *application) recoverPanic() gin.HandlerFunc {
 	}
 }
 
-func (app *application) rateLimitor() gin.HandlerFunc {
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
+// deadline bounds the time spent on a request with the route's configured
+// timeout, cancelling database queries still running when it expires
+func (app *application) deadline() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		timeout := app.routeTimeout(ctx.Request.Method, ctx.FullPath())
+		if timeout <= 0 {
+			ctx.Next()
+			return
+		}
+
+		reqctx, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+		defer cancel()
+
+		ctx.Request = ctx.Request.WithContext(reqctx)
+		ctx.Next()
 	}
+}
 
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
+// routeTimeout picks the most specific configured timeout for a route
+func (app *application) routeTimeout(method, route string) time.Duration {
+	if timeout, found := app.config.Timeouts.Routes[method+" "+route]; found {
+		return timeout
+	}
 
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-			mu.Lock()
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
+	if timeout, found := app.config.Timeouts.Routes[route]; found {
+		return timeout
+	}
 
-			mu.Unlock()
-		}
-	}()
+	return app.config.Timeouts.Default
+}
+
+// limitPreAuth runs before authenticate with a coarse per IP quota, so requests
+// carrying bad tokens are counted too and guessing tokens stays throttled
+func (app *application) limitPreAuth() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		if app.config.Limiter.Enable {
-			ip, _, err := net.SplitHostPort(ctx.Request.RemoteAddr)
-			if err != nil {
-				ctx.Abort()
-				app.serverErrorResponse(ctx, err)
+			limit := ratelimit.Limit{Rate: app.config.Limiter.PreAuthRps, Burst: app.config.Limiter.PreAuthBurst}
+			if !app.allowRequest(ctx, "preauth", "ip:"+app.clientIP(ctx), limit) {
 				return
 			}
+		}
 
-			mu.Lock()
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.Limiter.Rps), app.config.Limiter.Burst),
-				}
-			}
+		ctx.Next()
+	}
+}
 
-			clients[ip].lastSeen = time.Now()
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				ctx.Abort()
-				app.rateLimitExceededResponse(ctx)
+// rateLimitor runs after authenticate so authenticated clients are limited per
+// user rather than per IP, with the quota picked by the limiter policy
+func (app *application) rateLimitor() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if app.config.Limiter.Enable {
+			key, authenticated := app.rateLimitKey(ctx)
+			group, limit := app.limitPolicy.Resolve(ctx.Request.Method, ctx.FullPath(), authenticated)
+			if !app.allowRequest(ctx, group, key, limit) {
 				return
 			}
-
-			mu.Unlock()
 		}
 
 		ctx.Next()
 	}
 }
 
+// allowRequest takes a token from the client's bucket in the group, setting the
+// RateLimit headers, and answers with a 429 when the bucket is empty
+func (app *application) allowRequest(ctx *gin.Context, group, key string, limit ratelimit.Limit) bool {
+	result, err := app.limiter.Allow(ctx.Request.Context(), group+":"+key, limit)
+	if err != nil {
+		// a shared backend going away shouldn't take the API down with it, so
+		// the request is let through. Every failure is counted but only the
+		// first of an outage is logged, not one line per request
+		metrics.RateLimitErrors.Inc()
+		if app.limiterFailing.CompareAndSwap(false, true) {
+			app.logError(ctx.Request, fmt.Errorf("rate limiter unavailable, letting requests through: %w", err))
+		}
+		return true
+	}
+
+	if app.limiterFailing.CompareAndSwap(true, false) {
+		app.logger.PrintInfo("rate limiter recovered", nil)
+	}
+
+	ctx.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	ctx.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	ctx.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+
+	if !result.Allowed {
+		metrics.RateLimitRejections.WithLabelValues(group).Inc()
+		ctx.Header("Retry-After", strconv.Itoa(max(1, ceilSeconds(result.RetryAfter))))
+		ctx.Abort()
+		app.rateLimitExceededResponse(ctx)
+		return false
+	}
+
+	return true
+}
+
+// rateLimitKey identifies the client, preferring the authenticated user over
+// the client IP
+func (app *application) rateLimitKey(ctx *gin.Context) (string, bool) {
+	if user, ok := ctx.Value("user").(*data.User); ok && !user.IsAnonymous() {
+		return "user:" + strconv.FormatInt(user.ID, 10), true
+	}
+
+	return "ip:" + app.clientIP(ctx), false
+}
+
+// traceID returns the ID of the trace the request belongs to, or "" when it isn't traced
+func traceID(ctx *gin.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx.Request.Context())
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+
+	return spanContext.TraceID().String()
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
 func (app *application) authenticate() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		ctx.Writer.Header().Add("Vary", "Authorization")
@@ -117,7 +236,7 @@ func (app *application) authenticate() gin.HandlerFunc {
 		}
 
 		// retrieve the user from the token
-		user, err := app.models.UserModel.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.models.UserModel.Users.GetForToken(ctx.Request.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			ctx.Abort()
 			switch {
@@ -130,115 +249,195 @@ func (app *application) authenticate() gin.HandlerFunc {
 			return
 		}
 
-		// add the user info to the request
+		// add the user info to the request, keeping the token around so
+		// handlers can tell the current session apart from the others
 		ctx.Set("user", user)
+		ctx.Set("token", token)
 
 		// Do not call ctx.Next() here after authentication failure, return early with error response
 		ctx.Next()
 	}
 }
 
-func (app *application) requireAuthenticatedUser() gin.HandlerFunc {
+// authorize looks the matched route up in the policy engine and evaluates the
+// action it performs for the current user. Routes the policy doesn't list are
+// denied, so a handler added without a policy entry isn't served to anyone
+func (app *application) authorize() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		user, ok := ctx.Value("user").(*data.User)
-		if !ok {
-			panic("missing user value in request context")
+		// no route matched, the 404 or 405 handler answers
+		if ctx.FullPath() == "" {
+			ctx.Next()
+			return
 		}
 
-		if user.IsAnonymous() {
+		route, found := app.authz.Route(ctx.Request.Method, ctx.FullPath())
+		if !found {
+			app.logError(ctx.Request, fmt.Errorf("route %s %s is not covered by the authz policy", ctx.Request.Method, ctx.FullPath()))
 			ctx.Abort()
-			app.authenticationRequiredResponse(ctx)
+			app.notPermittedResponse(ctx)
 			return
 		}
-	}
-}
 
-func (app *application) requireActivatedUser() gin.HandlerFunc {
-	return func(ctx *gin.Context) {
-		app.requireAuthenticatedUser()(ctx)
-		if ctx.IsAborted() {
+		if route.Public {
+			ctx.Next()
 			return
 		}
+
 		user, ok := ctx.Value("user").(*data.User)
 		if !ok {
 			panic("missing user value in request context")
 		}
 
-		// log.Println(user.Name)
-		// if user.IsAnonymous() {
-		// 	ctx.Abort()
-		// 	app.authenticationRequiredResponse(ctx)
-		// 	return
-		// }
+		subject, err := app.authzSubject(ctx, user)
+		if err != nil {
+			ctx.Abort()
+			app.serverErrorResponse(ctx, err)
+			return
+		}
 
-		if !user.Activated {
+		decision := app.authz.Evaluate(authz.Request{
+			Subject:  subject,
+			Action:   route.Action,
+			Resource: authz.Resource{Type: route.Resource},
+		})
+
+		if !decision.Allowed {
 			ctx.Abort()
-			app.inactiveAccountResponse(ctx)
+			app.accessDeniedResponse(ctx, subject)
 			return
 		}
+
+		ctx.Next()
 	}
 }
 
-func (app *application) requirePermission(code string) gin.HandlerFunc {
+func (app *application) enableCORS() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		app.requireActivatedUser()(ctx)
-		if ctx.IsAborted() {
+		ctx.Writer.Header().Add("Vary", "Origin")
+		ctx.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+		ctx.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+
+		origin := ctx.Request.Header.Get("Origin")
+		policy := app.cors.For(ctx.Request.URL.Path)
+		if origin == "" || !policy.AllowsOrigin(origin) {
+			ctx.Next()
 			return
 		}
 
-		user, ok := ctx.Value("user").(*data.User)
-		if !ok {
-			panic("missing user value in request context")
+		if policy.AllowsAnyOrigin() {
+			ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			ctx.Writer.Header().Set("Access-Control-Allow-Origin", origin)
 		}
 
-		permissions, err := app.models.PermissionModel.Permissions.GetAllForUser(user.ID)
-		log.Println(permissions)
-		if err != nil {
-			ctx.Abort()
-			app.serverErrorResponse(ctx, err)
-			return
+		if policy.AllowCredentials {
+			ctx.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		}
 
-		if !permissions.Include(code) {
-			ctx.Abort()
-			app.notPermittedResponse(ctx)
+		// the http.MethodOptions header is sent by browser as a signal for preflight request
+		if ctx.Request.Method == http.MethodOptions && ctx.Request.Header.Get("Access-Control-Request-Method") != "" {
+			// set the necessary preflight response for our api
+			ctx.Writer.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.AllowedMethods, ", "))
+			ctx.Writer.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+			if policy.MaxAge > 0 {
+				ctx.Writer.Header().Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+			}
+
+			ctx.AbortWithStatus(http.StatusOK)
 			return
 		}
 
+		if len(policy.ExposedHeaders) > 0 {
+			ctx.Writer.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+		}
+
 		ctx.Next()
 	}
 }
 
-func (app *application) enableCORS() gin.HandlerFunc {
+// traceRequest starts the server span of the request, continuing the trace of
+// the caller when it sent a W3C traceparent header
+func (app *application) traceRequest() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		ctx.Writer.Header().Add("Vary", "Origin")
-		ctx.Writer.Header().Add("Vary", "Access-Control-Request-Method")
-		origin := ctx.Request.Header.Get("Origin")
-		log.Println(origin)
-		if origin != "" {
-			for i := range app.config.Cors.TrustedOrigins {
-				if origin == app.config.Cors.TrustedOrigins[i] {
-					ctx.Writer.Header().Set("Access-Control-Allow-Origin", origin)
-					log.Println(1)
-					// the http.MethodOptions header is sent by browser as a signal for preflight request
-					if ctx.Request.Method == http.MethodOptions && ctx.Request.Header.Get("Access-Control-Request-Method") != "" {
-
-						// set the necessary preflight response for our api
-						ctx.Writer.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						ctx.Writer.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
-						log.Println(2)
-						// write http.StatusOK in the header
-						ctx.Writer.WriteHeader(http.StatusOK)
-						log.Println(3)
-						return
-					}
-
-					break
-				}
-			}
+		parent := otel.GetTextMapPropagator().Extract(ctx.Request.Context(), propagation.HeaderCarrier(ctx.Request.Header))
+
+		route := ctx.FullPath()
+		name := ctx.Request.Method + " " + route
+		if route == "" {
+			name = ctx.Request.Method
 		}
 
+		spanCtx, span := tracing.Tracer().Start(parent, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(ctx.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(ctx.Request.URL.Path),
+				semconv.ClientAddress(app.clientIP(ctx)),
+				attribute.String("request.id", ctx.GetString("request_id")),
+			),
+		)
+		defer span.End()
+
+		ctx.Request = ctx.Request.WithContext(spanCtx)
 		ctx.Next()
+
+		status := ctx.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+
+		if user, ok := ctx.Value("user").(*data.User); ok && !user.IsAnonymous() {
+			span.SetAttributes(semconv.EnduserID(strconv.FormatInt(user.ID, 10)))
+		}
+	}
+}
+
+// accessLog writes a line per request through the application logger. Failed
+// requests are always logged while successful ones are sampled
+func (app *application) accessLog() gin.HandlerFunc {
+	excluded := make(map[string]bool, len(app.config.AccessLog.ExcludePaths))
+	for _, path := range app.config.AccessLog.ExcludePaths {
+		excluded[path] = true
+	}
+
+	return func(ctx *gin.Context) {
+		if !app.config.AccessLog.Enable || excluded[ctx.Request.URL.Path] {
+			ctx.Next()
+			return
+		}
+
+		start := time.Now()
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		if status < http.StatusBadRequest && rand.Float64() >= app.config.AccessLog.SampleRate {
+			return
+		}
+
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		userID := ""
+		if user, ok := ctx.Value("user").(*data.User); ok && !user.IsAnonymous() {
+			userID = strconv.FormatInt(user.ID, 10)
+		}
+
+		app.logger.PrintInfo("request completed", map[string]string{
+			"request_id":     ctx.GetString("request_id"),
+			"request_method": ctx.Request.Method,
+			"request_route":  route,
+			"request_url":    ctx.Request.URL.String(),
+			"status":         strconv.Itoa(status),
+			"bytes":          strconv.Itoa(max(0, ctx.Writer.Size())),
+			"latency_ms":     strconv.FormatFloat(float64(time.Since(start).Microseconds())/1000, 'f', 3, 64),
+			"client_ip":      app.clientIP(ctx),
+			"user_id":        userID,
+			"trace_id":       traceID(ctx),
+		})
 	}
 }
 
@@ -252,9 +451,21 @@ func (app *application) metrics() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		start := time.Now()
 		totalRequestReceived.Add(1)
+		metrics.HTTPRequestsInFlight.Inc()
+		defer metrics.HTTPRequestsInFlight.Dec()
+
 		ctx.Next()
+
 		totalResponsesSent.Add(1)
-		duration := time.Since(start).Microseconds()
-		totalProcessingTimeMicroseconds.Add(duration)
+		duration := time.Since(start)
+		totalProcessingTimeMicroseconds.Add(duration.Microseconds())
+
+		// label by route pattern rather than URL so IDs don't blow up the cardinality
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.HTTPRequests.WithLabelValues(route, ctx.Request.Method, strconv.Itoa(ctx.Writer.Status())).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(route, ctx.Request.Method).Observe(duration.Seconds())
 	}
 }
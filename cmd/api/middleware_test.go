@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"greenlight.fyerfyer.net/internal/cors"
+)
+
+func TestEnableCORS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	policy, err := cors.New(cors.Policy{
+		AllowedOrigins: []string{"https://*.example.com"},
+		AllowedMethods: []string{"GET", "PATCH"},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+		ExposedHeaders: []string{"X-Request-ID"},
+		MaxAge:         600,
+	}, "")
+	if err != nil {
+		t.Fatalf("cors.New: %v", err)
+	}
+
+	app := &application{cors: policy}
+	r := gin.New()
+	r.Use(app.enableCORS())
+	r.Handle(http.MethodGet, "/v1/movies", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	r.Handle(http.MethodOptions, "/v1/movies", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	tests := []struct {
+		name          string
+		method        string
+		origin        string
+		preflight     bool
+		status        int
+		allowOrigin   string
+		allowMethods  string
+		exposeHeaders string
+	}{
+		{
+			name:          "simple request from an allowed origin",
+			method:        http.MethodGet,
+			origin:        "https://app.example.com",
+			status:        http.StatusNoContent,
+			allowOrigin:   "https://app.example.com",
+			exposeHeaders: "X-Request-ID",
+		},
+		{
+			name:   "simple request from another origin",
+			method: http.MethodGet,
+			origin: "https://evil.com",
+			status: http.StatusNoContent,
+		},
+		{
+			name:   "simple request without an origin",
+			method: http.MethodGet,
+			status: http.StatusNoContent,
+		},
+		{
+			name:         "preflight from an allowed origin",
+			method:       http.MethodOptions,
+			origin:       "https://app.example.com",
+			preflight:    true,
+			status:       http.StatusOK,
+			allowOrigin:  "https://app.example.com",
+			allowMethods: "GET, PATCH",
+		},
+		{
+			name:      "preflight from another origin",
+			method:    http.MethodOptions,
+			origin:    "https://app.example.com.evil.com",
+			preflight: true,
+			status:    http.StatusNoContent,
+		},
+		{
+			name:   "OPTIONS without a requested method is no preflight",
+			method: http.MethodOptions,
+			origin: "https://app.example.com",
+			status: http.StatusNoContent,
+			// an allowed origin still gets its CORS headers
+			allowOrigin:   "https://app.example.com",
+			exposeHeaders: "X-Request-ID",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/v1/movies", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+			}
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Errorf("status = %d, want %d", rr.Code, tt.status)
+			}
+
+			// caches must key on the origin whether or not it was allowed
+			vary := rr.Header().Values("Vary")
+			for _, header := range []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"} {
+				if !slices.Contains(vary, header) {
+					t.Errorf("Vary = %q, missing %s", vary, header)
+				}
+			}
+
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.allowOrigin)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Methods"); got != tt.allowMethods {
+				t.Errorf("Access-Control-Allow-Methods = %q, want %q", got, tt.allowMethods)
+			}
+			if got := rr.Header().Get("Access-Control-Expose-Headers"); got != tt.exposeHeaders {
+				t.Errorf("Access-Control-Expose-Headers = %q, want %q", got, tt.exposeHeaders)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"greenlight.fyerfyer.net/internal/validator"
+)
+
+const (
+	modeNormal      = "normal"
+	modeReadOnly    = "read_only"
+	modeMaintenance = "maintenance"
+)
+
+// serviceMode is what the API currently accepts: everything, only reads, or
+// nothing but the healthcheck and the admin mode switch
+type serviceMode struct {
+	Mode       string        `json:"mode"`
+	RetryAfter time.Duration `json:"-"`
+	Since      time.Time     `json:"since"`
+}
+
+// modeExempt are the routes served whatever the mode, so the API can be
+// monitored and switched back
+var modeExempt = map[string]bool{
+	"/v1/healthcheck": true,
+	"/v1/admin/mode":  true,
+}
+
+type modeSwitch struct {
+	current atomic.Pointer[serviceMode]
+}
+
+func (s *modeSwitch) Get() *serviceMode {
+	if mode := s.current.Load(); mode != nil {
+		return mode
+	}
+
+	return &serviceMode{Mode: modeNormal}
+}
+
+func (s *modeSwitch) Set(mode string, retryAfter time.Duration) *serviceMode {
+	m := &serviceMode{Mode: mode, RetryAfter: retryAfter, Since: time.Now()}
+	s.current.Store(m)
+	return m
+}
+
+// enforceMode turns requests the current mode doesn't accept away with a 503
+func (app *application) enforceMode() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		mode := app.mode.Get()
+		if mode.Mode == modeNormal || modeExempt[ctx.FullPath()] {
+			ctx.Next()
+			return
+		}
+
+		if mode.Mode == modeReadOnly {
+			switch ctx.Request.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				ctx.Next()
+				return
+			}
+		}
+
+		app.unavailableResponse(ctx, mode)
+		ctx.Abort()
+	}
+}
+
+func (app *application) setMode(mode string, retryAfter time.Duration) *serviceMode {
+	m := app.mode.Set(mode, retryAfter)
+	app.logger.PrintInfo("service mode changed", map[string]string{
+		"mode": m.Mode,
+	})
+	return m
+}
+
+// watchModeSignals lets operators flip the mode without credentials: SIGUSR1
+// toggles read-only mode and SIGUSR2 toggles maintenance mode
+func (app *application) watchModeSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for s := range signals {
+			target := modeReadOnly
+			if s == syscall.SIGUSR2 {
+				target = modeMaintenance
+			}
+
+			if app.mode.Get().Mode == target {
+				target = modeNormal
+			}
+
+			app.setMode(target, app.config.Mode.RetryAfter)
+		}
+	}()
+}
+
+func (app *application) showModeHandler(c *gin.Context) {
+	err := app.writeJSON(c, http.StatusOK, envelope{"mode": app.mode.Get()})
+	if err != nil {
+		app.serverErrorResponse(c, err)
+	}
+}
+
+func (app *application) updateModeHandler(c *gin.Context) {
+	var input struct {
+		Mode       string `json:"mode"`
+		RetryAfter *int   `json:"retry_after"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	retryAfter := app.config.Mode.RetryAfter
+	if input.RetryAfter != nil {
+		retryAfter = time.Duration(*input.RetryAfter) * time.Second
+	}
+
+	v := validator.New()
+	v.Check(validator.PermittedValue(input.Mode, modeNormal, modeReadOnly, modeMaintenance), "mode", "invalid_mode")
+	v.Check(retryAfter >= 0, "retry_after", "not_negative")
+	if !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	mode := app.setMode(input.Mode, retryAfter)
+
+	// there may be no database to write the audit entry to, which is fine
+	app.recordAudit(c, "service.mode", 0, map[string]string{
+		"mode": mode.Mode,
+	})
+
+	err = app.writeJSON(c, http.StatusOK, envelope{"mode": mode})
+	if err != nil {
+		app.serverErrorResponse(c, err)
+	}
+}
@@ -20,7 +20,7 @@ func (app *application) showMovieHandler(c *gin.Context) {
 		return
 	}
 
-	movie, err := app.models.MovieModel.Movies.Get(int64(id))
+	movie, err := app.models.MovieModel.Movies.Get(c.Request.Context(), int64(id))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -51,11 +51,13 @@ func (app *application) createMovieHandler(c *gin.Context) {
 		return
 	}
 
+	user := c.Value("user").(*data.User)
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:     input.Title,
+		Year:      input.Year,
+		Runtime:   input.Runtime,
+		Genres:    input.Genres,
+		CreatedBy: user.ID,
 	}
 
 	v := validator.New()
@@ -65,7 +67,7 @@ func (app *application) createMovieHandler(c *gin.Context) {
 		return
 	}
 
-	err = app.models.MovieModel.Movies.Insert(movie)
+	err = app.models.MovieModel.Movies.Insert(c.Request.Context(), movie)
 	if err != nil {
 		log.Println("Insert error:", err)
 		app.serverErrorResponse(c, err)
@@ -87,7 +89,7 @@ func (app *application) updateMovieHandler(c *gin.Context) {
 	}
 
 	movie := &data.Movie{}
-	movie, err = app.models.MovieModel.Movies.Get(int64(id))
+	movie, err = app.models.MovieModel.Movies.Get(c.Request.Context(), int64(id))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -98,6 +100,18 @@ func (app *application) updateMovieHandler(c *gin.Context) {
 		return
 	}
 
+	user := c.Value("user").(*data.User)
+	allowed, err := app.canEditMovie(c, user, movie)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	if !allowed {
+		app.notPermittedResponse(c)
+		return
+	}
+
 	var input struct {
 		Title   *string       `json:"title"`
 		Year    *int          `json:"year"`
@@ -131,7 +145,7 @@ func (app *application) updateMovieHandler(c *gin.Context) {
 	}
 
 	// movie.Version += 1
-	err = app.models.MovieModel.Movies.Update(movie)
+	err = app.models.MovieModel.Movies.Update(c.Request.Context(), movie)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -155,7 +169,30 @@ func (app *application) deleteMovieHandler(c *gin.Context) {
 		return
 	}
 
-	err = app.models.MovieModel.Movies.Delete(int64(id))
+	movie, err := app.models.MovieModel.Movies.Get(c.Request.Context(), int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	user := c.Value("user").(*data.User)
+	allowed, err := app.canManageMovie(c, user, movie)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	if !allowed {
+		app.notPermittedResponse(c)
+		return
+	}
+
+	err = app.models.MovieModel.Movies.Delete(c.Request.Context(), movie.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -194,13 +231,159 @@ func (app *application) listMoviesHandler(c *gin.Context) {
 		return
 	}
 
-	movies, metadata, err := app.models.MovieModel.Movies.GetAll(input.Title, input.Genres, input.Filter)
+	movies, metadata, err := app.models.MovieModel.Movies.GetAll(c.Request.Context(), input.Title, input.Genres, input.Filter)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = writeJSONList(c, http.StatusOK, "movies", movies, envelope{"metadata": metadata})
 	if err != nil {
+		// past the first byte the best we can do is log the failure
+		if c.Writer.Written() {
+			app.logError(c.Request, err)
+			return
+		}
 		app.serverErrorResponse(c, err)
+	}
+}
+
+// movieForManagement loads the movie named by the :id parameter and checks the
+// current user may manage it, writing the error response itself when it returns nil
+func (app *application) movieForManagement(c *gin.Context) *data.Movie {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(c)
+		return nil
+	}
+
+	movie, err := app.models.MovieModel.Movies.Get(c.Request.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return nil
+	}
+
+	user := c.Value("user").(*data.User)
+	allowed, err := app.canManageMovie(c, user, movie)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return nil
+	}
+
+	if !allowed {
+		app.notPermittedResponse(c)
+		return nil
+	}
+
+	return movie
+}
+
+func (app *application) listMovieCollaboratorsHandler(c *gin.Context) {
+	movie := app.movieForManagement(c)
+	if movie == nil {
+		return
+	}
+
+	collaborators, err := app.models.MovieCollaboratorModel.Collaborators.GetAllForMovie(c.Request.Context(), movie.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = app.writeJSON(c, http.StatusOK, envelope{"collaborators": collaborators})
+	if err != nil {
+		app.serverErrorResponse(c, err)
+	}
+}
+
+func (app *application) addMovieCollaboratorHandler(c *gin.Context) {
+	movie := app.movieForManagement(c)
+	if movie == nil {
+		return
+	}
+
+	var input struct {
+		UserID int64 `json:"user_id"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.UserID > 0, "user_id", "required")
+	v.Check(input.UserID != movie.CreatedBy, "user_id", "owner_cannot_collaborate")
+	if !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	_, err = app.models.UserModel.Users.Get(c.Request.Context(), input.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("user_id", "user_not_found")
+			app.failedValidationResponse(c, v.Errors)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	collaborator := &data.MovieCollaborator{
+		MovieID: movie.ID,
+		UserID:  input.UserID,
+	}
+
+	err = app.models.MovieCollaboratorModel.Collaborators.Insert(c.Request.Context(), collaborator)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateCollaborator):
+			v.AddError("user_id", "already_collaborator")
+			app.failedValidationResponse(c, v.Errors)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(c, http.StatusCreated, envelope{"collaborator": collaborator})
+	if err != nil {
+		app.serverErrorResponse(c, err)
+	}
+}
+
+func (app *application) removeMovieCollaboratorHandler(c *gin.Context) {
+	movie := app.movieForManagement(c)
+	if movie == nil {
+		return
+	}
+
+	userID, err := strconv.ParseInt(c.Param("user_id"), 10, 64)
+	if err != nil || userID < 1 {
+		app.notFoundResponse(c)
+		return
+	}
+
+	err = app.models.MovieCollaboratorModel.Collaborators.Delete(c.Request.Context(), movie.ID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(c, http.StatusOK, envelope{"movies": movies, "metadata": metadata})
+	err = app.writeJSON(c, http.StatusOK, envelope{"message": "collaborator successfully removed"})
 	if err != nil {
 		app.serverErrorResponse(c, err)
 	}
@@ -2,47 +2,126 @@ package main
 
 import (
 	"expvar"
+	"net/http"
+	"net/http/pprof"
 
 	"github.com/gin-gonic/gin"
+	"greenlight.fyerfyer.net/internal/data"
+	"greenlight.fyerfyer.net/internal/metrics"
 )
 
 func (app *application) routes() *gin.Engine {
 	r := gin.New()
 
-	// r.Use(gin.Logger())
 	// r.Use(gin.Recovery())
+	r.Use(app.requestID())
+	r.Use(app.hsts())
+	r.Use(app.resolveClientIP())
+	r.Use(app.traceRequest())
+	r.Use(app.accessLog())
 	r.Use(app.metrics())
+	r.Use(app.compress())
 	r.Use(app.recoverPanic())
+
+	// probes are registered ahead of the remaining middleware, so neither
+	// service modes, authentication nor rate limiting get in their way
+	r.GET("/livez", app.livezHandler)
+	r.GET("/readyz", app.readyzHandler)
+
 	r.Use(app.enableCORS())
-	r.Use(app.rateLimitor())
+	r.Use(app.deadline())
+	// before authenticate, so maintenance mode doesn't touch the database
+	r.Use(app.enforceMode())
+	// a coarse per IP limit counts every request, bad tokens included, then
+	// the per user quotas apply once authenticate has identified the user
+	r.Use(app.limitPreAuth())
 	r.Use(app.authenticate())
+	r.Use(app.rateLimitor())
+	r.Use(app.authorize())
 	r.GET("/v1/healthcheck", app.healthcheckHandler)
-	r.POST("/v1/users", app.registerUserHandler)
+	r.POST("/v1/users", app.idempotent(), app.registerUserHandler)
 	r.PUT("/v1/users/activated", app.activateUserHandler)
 	r.POST("/v1/tokens/authentication", app.createAuthenticationTokenHandler)
 	r.POST("/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
 	r.PUT("/v1/users/password", app.updateUserPasswordHandler)
 	r.POST("/v1/tokens/activation", app.createActivateUserTokenHandler)
 
+	// access to the groups below is decided per route by the authz policy
+	apiv1Me := r.Group("/v1/users/me")
+	{
+		apiv1Me.GET("", app.showCurrentUserHandler)
+		apiv1Me.PUT("/password", app.changeUserPasswordHandler)
+	}
+
 	apiv1Read := r.Group("/v1")
-	apiv1Write := r.Group("/v1")
-	apiv1Read.Use(app.requirePermission("movies:read"))
 	{
 		apiv1Read.GET("/movies", app.listMoviesHandler)
 		apiv1Read.GET("/movies/:id", app.showMovieHandler)
 	}
 
-	apiv1Write.Use(app.requirePermission("movies:write"))
+	apiv1Write := r.Group("/v1")
 	{
-		apiv1Write.POST("/movies", app.createMovieHandler)
+		apiv1Write.POST("/movies", app.idempotent(), app.createMovieHandler)
 		apiv1Write.PATCH("/movies/:id", app.updateMovieHandler)
 		apiv1Write.DELETE("/movies/:id", app.deleteMovieHandler)
+		apiv1Write.GET("/movies/:id/collaborators", app.listMovieCollaboratorsHandler)
+		apiv1Write.POST("/movies/:id/collaborators", app.idempotent(), app.addMovieCollaboratorHandler)
+		apiv1Write.DELETE("/movies/:id/collaborators/:user_id", app.removeMovieCollaboratorHandler)
 	}
 
-	r.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+	apiv1Admin := r.Group("/v1/admin")
+	{
+		apiv1Admin.GET("/users", app.listUsersHandler)
+		apiv1Admin.GET("/users/:id", app.showUserHandler)
+		apiv1Admin.PUT("/users/:id/activated", app.updateUserActivationHandler)
+		apiv1Admin.POST("/users/:id/password-reset", app.forceUserPasswordResetHandler)
+		apiv1Admin.DELETE("/users/:id/tokens", app.revokeUserTokensHandler)
+		apiv1Admin.DELETE("/users/:id", app.deleteUserHandler)
+		apiv1Admin.POST("/authz/check", app.checkAuthorizationHandler)
+		apiv1Admin.GET("/mode", app.showModeHandler)
+		apiv1Admin.PUT("/mode", app.updateModeHandler)
+	}
 
 	r.NoRoute(app.notFoundResponse)
 	r.NoMethod(app.methodNotAllowedResponse)
 
 	return r
 }
+
+// adminRoutes is served by the admin listener, which isn't exposed publicly
+// and so needs no authentication
+func (app *application) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	mux.Handle("GET /debug/vars", expvar.Handler())
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	// admin tools reuse the API handlers, acting as the anonymous user
+	tools := gin.New()
+	tools.Use(app.requestID())
+	tools.Use(app.resolveClientIP())
+	tools.Use(app.recoverPanic())
+	// the same request deadline as the public routes, so the audit insert of
+	// a mode switch can't hang on the database
+	tools.Use(app.deadline())
+	tools.Use(func(ctx *gin.Context) {
+		ctx.Set("user", data.AnonymousUser)
+		ctx.Next()
+	})
+	tools.GET("/livez", app.livezHandler)
+	tools.GET("/readyz", app.adminReadyzHandler)
+	tools.GET("/mode", app.showModeHandler)
+	tools.PUT("/mode", app.updateModeHandler)
+	tools.NoRoute(app.notFoundResponse)
+
+	mux.Handle("/livez", tools)
+	mux.Handle("/readyz", tools)
+	mux.Handle("/mode", tools)
+
+	return mux
+}
@@ -2,25 +2,72 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
+
+	"greenlight.fyerfyer.net/internal/tlsconfig"
 )
 
 func (app *application) serve() error {
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", app.config.Port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+	srv := app.newServer(fmt.Sprintf(":%d", app.config.Port), app.routes())
+	adminSrv := app.newServer(app.config.AdminAddr, app.adminRoutes())
+
+	servers := []*http.Server{srv, adminSrv}
+
+	useTLS := app.config.TLS.CertFile != "" && app.config.TLS.KeyFile != ""
+	if useTLS {
+		tlsConfig, err := tlsconfig.New(tlsconfig.Options{
+			CertFile:       app.config.TLS.CertFile,
+			KeyFile:        app.config.TLS.KeyFile,
+			MinVersion:     app.config.TLS.MinVersion,
+			CipherSuites:   app.config.TLS.CipherSuites,
+			HTTP2:          app.config.TLS.HTTP2,
+			ReloadInterval: app.config.TLS.ReloadInterval,
+		}, func(err error) {
+			app.logger.PrintError(err, map[string]string{
+				"cert_file": app.config.TLS.CertFile,
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		srv.TLSConfig = tlsConfig
+		if !app.config.TLS.HTTP2 {
+			// a non-nil empty map is how net/http is told to leave HTTP/2 off
+			srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+	}
+
+	// an empty address disables the admin listener, otherwise it's bound up
+	// front so a taken address stops the startup
+	if adminSrv.Addr != "" {
+		if err := app.serveInBackground(adminSrv); err != nil {
+			return err
+		}
+	}
+
+	if useTLS && app.config.TLS.RedirectAddr != "" {
+		redirectSrv := app.newServer(app.config.TLS.RedirectAddr, app.redirectToHTTPS())
+		if err := app.serveInBackground(redirectSrv); err != nil {
+			return err
+		}
+
+		servers = append(servers, redirectSrv)
 	}
 
-	shutdownError := make(chan error)
+	app.watchModeSignals()
+
+	shutdownError := make(chan error, 1)
 
 	// start a background goroutine
 	go func() {
@@ -32,31 +79,32 @@ func (app *application) serve() error {
 			"signal": s.String(),
 		})
 
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		err := srv.Shutdown(ctx)
-		if err != nil {
-			shutdownError <- err
+		// fail readiness first and keep serving while the orchestrator notices
+		app.shuttingDown.Store(true)
+		if app.config.Server.ShutdownDelay > 0 {
+			app.logger.PrintInfo("failing readiness before shutdown", map[string]string{
+				"delay": app.config.Server.ShutdownDelay.String(),
+			})
+			time.Sleep(app.config.Server.ShutdownDelay)
 		}
 
-		app.logger.PrintInfo("completing background tasks", map[string]string{
-			"addr": srv.Addr,
-		})
-
-		// after waiting for the group to complete
-		// we send nil to the channel
-		// indicating that there's no more issue
-		app.wg.Wait()
-		shutdownError <- nil
+		shutdownError <- app.shutdown(servers)
 	}()
 
 	app.logger.PrintInfo("starting server", map[string]string{
-		"addr": srv.Addr,
-		"env":  app.config.Env,
+		"addr":       srv.Addr,
+		"admin_addr": adminSrv.Addr,
+		"env":        app.config.Env,
+		"tls":        strconv.FormatBool(useTLS),
 	})
 
-	err := srv.ListenAndServe()
+	var err error
+	if useTLS {
+		// the certificate comes from the TLS config, so no files are passed here
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 
 	// if err is http.ErrServerClosed, then we shutdown gracefully
 	if err != nil {
@@ -77,3 +125,94 @@ func (app *application) serve() error {
 
 	return nil
 }
+
+func (app *application) newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		IdleTimeout:       app.config.Server.IdleTimeout,
+		ReadTimeout:       app.config.Server.ReadTimeout,
+		ReadHeaderTimeout: app.config.Server.ReadHeaderTimeout,
+		WriteTimeout:      app.config.Server.WriteTimeout,
+		MaxHeaderBytes:    app.config.Server.MaxHeaderBytes,
+	}
+}
+
+// serveInBackground binds the listener straight away, so a taken address is
+// reported to the caller, and serves it in its own goroutine
+func (app *application) serveInBackground(srv *http.Server) error {
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		err := srv.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			app.logger.PrintError(err, map[string]string{
+				"addr": srv.Addr,
+			})
+		}
+	}()
+
+	return nil
+}
+
+// shutdown stops accepting requests and waits for the in-flight ones, then
+// gives background tasks until the drain deadline to finish. Tasks still
+// running then are logged and abandoned rather than holding up the exit
+func (app *application) shutdown(servers []*http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), app.config.Server.ShutdownTimeout)
+	defer cancel()
+
+	var err error
+	for _, s := range servers {
+		if shutdownErr := s.Shutdown(ctx); err == nil {
+			err = shutdownErr
+		}
+	}
+
+	app.logger.PrintInfo("completing background tasks", map[string]string{
+		"addr":    servers[0].Addr,
+		"running": strconv.Itoa(len(app.tasks.describe())),
+	})
+
+	drained := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(app.config.Server.DrainTimeout):
+		unfinished := app.tasks.describe()
+		app.logger.PrintError(errors.New("background tasks did not finish before the drain deadline"), map[string]string{
+			"unfinished": strconv.Itoa(len(unfinished)),
+			"tasks":      strings.Join(unfinished, "; "),
+		})
+	}
+
+	return err
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL on the HTTPS port,
+// keeping the method for anything but GET and HEAD
+func (app *application) redirectToHTTPS() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if app.config.Port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(app.config.Port))
+		}
+
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
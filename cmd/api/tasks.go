@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type backgroundTask struct {
+	name      string
+	requestID string
+	started   time.Time
+}
+
+// backgroundTasks keeps track of the running background tasks, so the ones
+// still running when the shutdown gives up on them can be reported
+type backgroundTasks struct {
+	mu      sync.Mutex
+	next    int64
+	running map[int64]backgroundTask
+}
+
+func (t *backgroundTasks) start(name, requestID string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.running == nil {
+		t.running = make(map[int64]backgroundTask)
+	}
+
+	t.next++
+	t.running[t.next] = backgroundTask{name: name, requestID: requestID, started: time.Now()}
+	return t.next
+}
+
+func (t *backgroundTasks) finish(id int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.running, id)
+}
+
+// describe lists the running tasks, oldest first, as
+// "name (request <id>, running for <duration>)"
+func (t *backgroundTasks) describe() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tasks := make([]backgroundTask, 0, len(t.running))
+	for _, task := range t.running {
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].started.Before(tasks[j].started) })
+
+	descriptions := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		descriptions = append(descriptions, fmt.Sprintf("%s (request %s, running for %s)",
+			task.name, task.requestID, time.Since(task.started).Round(time.Millisecond)))
+	}
+
+	return descriptions
+}
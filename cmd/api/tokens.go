@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"time"
@@ -32,7 +33,7 @@ func (app *application) createAuthenticationTokenHandler(c *gin.Context) {
 		return
 	}
 
-	user, err := app.models.UserModel.Users.GetByEmail(input.Email)
+	user, err := app.models.UserModel.Users.GetByEmail(c.Request.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -56,7 +57,7 @@ func (app *application) createAuthenticationTokenHandler(c *gin.Context) {
 		return
 	}
 
-	token, err := app.models.TokenModel.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	token, err := app.models.TokenModel.Tokens.NewForClient(c.Request.Context(), user.ID, 24*time.Hour, data.ScopeAuthentication, app.clientIP(c))
 	if err != nil {
 		app.serverErrorResponse(c, err)
 		return
@@ -85,11 +86,11 @@ func (app *application) createPasswordResetTokenHandler(c *gin.Context) {
 		return
 	}
 
-	user, err := app.models.UserModel.Users.GetByEmail(input.Email)
+	user, err := app.models.UserModel.Users.GetByEmail(c.Request.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("email", "no matching email address found")
+			v.AddError("email", "email_not_found")
 			app.failedValidationResponse(c, v.Errors)
 		default:
 			app.serverErrorResponse(c, err)
@@ -100,27 +101,27 @@ func (app *application) createPasswordResetTokenHandler(c *gin.Context) {
 
 	// check if the user is activated
 	if !user.Activated {
-		v.AddError("email", "user account must be activated")
+		v.AddError("email", "account_not_activated")
 		app.failedValidationResponse(c, v.Errors)
 		return
 	}
 
 	// generate token for resetting password
-	token, err := app.models.TokenModel.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordRest)
+	token, err := app.models.TokenModel.Tokens.New(c.Request.Context(), user.ID, 45*time.Minute, data.ScopePasswordRest)
 	if err != nil {
 		app.serverErrorResponse(c, err)
 		return
 	}
 
 	// email the user
-	app.background(func() {
+	app.background(c, "password reset email", func(ctx context.Context) {
 		data := map[string]interface{}{
 			"passwordResetToken": token.Plaintext,
 		}
 
-		err = app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		err := app.mailer.Send(ctx, user.Email, "token_password_reset.tmpl", data)
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			app.logBackgroundError(ctx, err)
 		}
 	})
 
@@ -149,11 +150,11 @@ func (app *application) createActivateUserTokenHandler(c *gin.Context) {
 		return
 	}
 
-	user, err := app.models.UserModel.Users.GetByEmail(input.Email)
+	user, err := app.models.UserModel.Users.GetByEmail(c.Request.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("email", "no matching email address found")
+			v.AddError("email", "email_not_found")
 			app.failedValidationResponse(c, v.Errors)
 		default:
 			app.serverErrorResponse(c, err)
@@ -163,25 +164,25 @@ func (app *application) createActivateUserTokenHandler(c *gin.Context) {
 	}
 
 	if user.Activated {
-		v.AddError("email", "user has already been activated")
+		v.AddError("email", "already_activated")
 		app.failedValidationResponse(c, v.Errors)
 		return
 	}
 
-	token, err := app.models.TokenModel.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	token, err := app.models.TokenModel.Tokens.New(c.Request.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(c, err)
 		return
 	}
 
-	app.background(func() {
+	app.background(c, "activation email", func(ctx context.Context) {
 		data := map[string]interface{}{
 			"activationToken": token.Plaintext,
 		}
 
-		err = app.mailer.Send(user.Email, "token_activation.tmpl", data)
+		err := app.mailer.Send(ctx, user.Email, "token_activation.tmpl", data)
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			app.logBackgroundError(ctx, err)
 		}
 	})
 
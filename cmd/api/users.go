@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	// "log"
 	"time"
@@ -45,11 +46,11 @@ func (app *application) registerUserHandler(c *gin.Context) {
 		return
 	}
 
-	err = app.models.UserModel.Users.Insert(user)
+	err = app.models.UserModel.Users.Insert(c.Request.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
-			v.AddError("email", "a user with this email address already exists")
+			v.AddError("email", "duplicate_email")
 			app.failedValidationResponse(c, v.Errors)
 		default:
 			app.serverErrorResponse(c, err)
@@ -58,26 +59,26 @@ func (app *application) registerUserHandler(c *gin.Context) {
 		return
 	}
 
-	err = app.models.PermissionModel.Permissions.AddForUser(user.ID, "movies:read")
+	err = app.models.PermissionModel.Permissions.AddForUser(c.Request.Context(), user.ID, "movies:read")
 	if err != nil {
 		app.serverErrorResponse(c, err)
 		return
 	}
 
-	token, err := app.models.TokenModel.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	token, err := app.models.TokenModel.Tokens.New(c.Request.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(c, err)
 		return
 	}
 
-	app.background(func() {
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", map[string]interface{}{
+	app.background(c, "welcome email", func(ctx context.Context) {
+		err := app.mailer.Send(ctx, user.Email, "user_welcome.tmpl", map[string]interface{}{
 			"activationToken": token.Plaintext,
 			"userID":          user.ID,
 		})
 
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			app.logBackgroundError(ctx, err)
 		}
 	})
 
@@ -107,11 +108,11 @@ func (app *application) activateUserHandler(c *gin.Context) {
 
 	// retrive the details of the user associated with the token
 	// using the token details
-	user, err := app.models.UserModel.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.models.UserModel.Users.GetForToken(c.Request.Context(), data.ScopeActivation, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("token", "invalid or expired activation token")
+			v.AddError("token", "invalid_activation_token")
 			app.failedValidationResponse(c, v.Errors)
 		default:
 			app.serverErrorResponse(c, err)
@@ -122,7 +123,7 @@ func (app *application) activateUserHandler(c *gin.Context) {
 
 	user.Activated = true
 
-	err = app.models.UserModel.Users.Update(user)
+	err = app.models.UserModel.Users.Update(c.Request.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -134,7 +135,7 @@ func (app *application) activateUserHandler(c *gin.Context) {
 		return
 	}
 
-	err = app.models.TokenModel.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	err = app.models.TokenModel.Tokens.DeleteAllForUser(c.Request.Context(), data.ScopeActivation, user.ID)
 	if err != nil {
 		app.serverErrorResponse(c, err)
 		return
@@ -168,10 +169,11 @@ func (app *application) updateUserPasswordHandler(c *gin.Context) {
 	}
 
 	// get the user
-	user, err := app.models.UserModel.Users.GetForToken(data.ScopePasswordRest, input.TokenPlaintext)
+	user, err := app.models.UserModel.Users.GetForToken(c.Request.Context(), data.ScopePasswordRest, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid_reset_token")
 			app.failedValidationResponse(c, v.Errors)
 		default:
 			app.serverErrorResponse(c, err)
@@ -187,7 +189,7 @@ func (app *application) updateUserPasswordHandler(c *gin.Context) {
 		return
 	}
 
-	err = app.models.UserModel.Users.Update(user)
+	err = app.models.UserModel.Users.Update(c.Request.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -198,7 +200,7 @@ func (app *application) updateUserPasswordHandler(c *gin.Context) {
 	}
 
 	// delete the reset token
-	err = app.models.TokenModel.Tokens.DeleteAllForUser(data.ScopePasswordRest, user.ID)
+	err = app.models.TokenModel.Tokens.DeleteAllForUser(c.Request.Context(), data.ScopePasswordRest, user.ID)
 	if err != nil {
 		app.serverErrorResponse(c, err)
 		return
@@ -211,3 +213,137 @@ func (app *application) updateUserPasswordHandler(c *gin.Context) {
 		app.serverErrorResponse(c, err)
 	}
 }
+
+func (app *application) changeUserPasswordHandler(c *gin.Context) {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.CurrentPassword != "", "current_password", "required")
+	data.ValidatePasswordPlaintext(v, input.Password)
+	v.Check(input.Password != input.CurrentPassword, "password", "same_password")
+
+	if !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	user := c.Value("user").(*data.User)
+
+	// check the current password before accepting the new one
+	match, err := user.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	if !match {
+		v.AddError("current_password", "incorrect_password")
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	err = user.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = app.models.UserModel.Users.Update(c.Request.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+
+		return
+	}
+
+	// sign out every other session, and make any outstanding reset token useless
+	err = app.models.TokenModel.Tokens.DeleteAllForUserExcept(c.Request.Context(), data.ScopeAuthentication, user.ID, c.GetString("token"))
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = app.models.TokenModel.Tokens.DeleteAllForUser(c.Request.Context(), data.ScopePasswordRest, user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.background(c, "password changed email", func(ctx context.Context) {
+		data := map[string]interface{}{
+			"changedAt": time.Now().UTC().Format(time.RFC1123),
+		}
+
+		err := app.mailer.Send(ctx, user.Email, "password_changed.tmpl", data)
+		if err != nil {
+			app.logBackgroundError(ctx, err)
+		}
+	})
+
+	err = app.writeJSON(c, http.StatusOK, envelope{
+		"message": "your password was successfully changed",
+	})
+	if err != nil {
+		app.serverErrorResponse(c, err)
+	}
+}
+
+func (app *application) showCurrentUserHandler(c *gin.Context) {
+	user := c.Value("user").(*data.User)
+
+	permissions, err := app.models.PermissionModel.Permissions.GetAllForUser(c.Request.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	token, err := app.models.TokenModel.Tokens.GetForPlaintext(c.Request.Context(), data.ScopeAuthentication, c.GetString("token"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+
+		return
+	}
+
+	activeSessions, err := app.models.TokenModel.Tokens.CountForUser(c.Request.Context(), data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = app.writeJSON(c, http.StatusOK, envelope{
+		"user":        user,
+		"activated":   user.Activated,
+		"permissions": permissions,
+		"session": map[string]interface{}{
+			"expiry":          token.Expiry,
+			"started_from_ip": token.ClientIP,
+			"client_ip":       app.clientIP(c),
+			"active_sessions": activeSessions,
+		},
+	})
+	if err != nil {
+		app.serverErrorResponse(c, err)
+	}
+}
@@ -0,0 +1,306 @@
+package authz
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+//go:embed default_policy.json
+var defaultPolicy []byte
+
+// Subject describes who is asking
+type Subject struct {
+	ID          int64    `json:"id"`
+	Anonymous   bool     `json:"anonymous"`
+	Activated   bool     `json:"activated"`
+	Permissions []string `json:"permissions"`
+}
+
+// Resource describes what is being acted on, attributes are free-form
+// strings such as the owner_id of a movie
+type Resource struct {
+	Type       string            `json:"type"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+type Request struct {
+	Subject  Subject  `json:"subject"`
+	Action   string   `json:"action"`
+	Resource Resource `json:"resource"`
+}
+
+// SubjectMatch lists the conditions a subject must meet, unset fields match anyone
+// and permissions match when the subject holds at least one of them
+type SubjectMatch struct {
+	Authenticated *bool    `json:"authenticated,omitempty"`
+	Activated     *bool    `json:"activated,omitempty"`
+	Permissions   []string `json:"permissions,omitempty"`
+}
+
+// ResourceMatch lists the conditions a resource must meet, owned matches when the
+// resource's owner_id attribute is the subject's id
+type ResourceMatch struct {
+	Type       string            `json:"type,omitempty"`
+	Owned      bool              `json:"owned,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+type Rule struct {
+	Name     string        `json:"name"`
+	Effect   string        `json:"effect"`
+	Actions  []string      `json:"actions"`
+	Subject  SubjectMatch  `json:"subject"`
+	Resource ResourceMatch `json:"resource"`
+}
+
+// Route binds a gin route pattern to the action it performs, a path ending
+// in /* covers everything below it. Public routes are served to anyone, every
+// route the policy doesn't list is denied
+type Route struct {
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Action   string `json:"action,omitempty"`
+	Resource string `json:"resource,omitempty"`
+	Public   bool   `json:"public,omitempty"`
+}
+
+type Policy struct {
+	Rules  []Rule  `json:"rules"`
+	Routes []Route `json:"routes"`
+}
+
+// RuleResult explains why a single rule did or didn't apply to a request
+type RuleResult struct {
+	Rule    string `json:"rule"`
+	Effect  string `json:"effect"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason"`
+}
+
+type Decision struct {
+	Allowed bool         `json:"allowed"`
+	Rule    string       `json:"rule,omitempty"`
+	Reason  string       `json:"reason"`
+	Trace   []RuleResult `json:"trace"`
+}
+
+type Engine struct {
+	policy Policy
+}
+
+// Default returns an engine using the policy shipped with the binary
+func Default() (*Engine, error) {
+	return parse(defaultPolicy)
+}
+
+// Load reads a policy file, falling back to the shipped policy when path is empty
+func Load(path string) (*Engine, error) {
+	if path == "" {
+		return Default()
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parse(content)
+}
+
+func parse(content []byte) (*Engine, error) {
+	var policy Policy
+	if err := json.Unmarshal(content, &policy); err != nil {
+		return nil, fmt.Errorf("authz: invalid policy: %w", err)
+	}
+
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+
+	return &Engine{policy: policy}, nil
+}
+
+func (p Policy) validate() error {
+	var errs []error
+	for i, rule := range p.Rules {
+		if rule.Name == "" {
+			errs = append(errs, fmt.Errorf("authz: rule %d: name must be provided", i))
+		}
+		if rule.Effect != EffectAllow && rule.Effect != EffectDeny {
+			errs = append(errs, fmt.Errorf("authz: rule %q: effect must be %q or %q", rule.Name, EffectAllow, EffectDeny))
+		}
+		if len(rule.Actions) == 0 {
+			errs = append(errs, fmt.Errorf("authz: rule %q: at least one action must be provided", rule.Name))
+		}
+	}
+
+	for i, route := range p.Routes {
+		if route.Method == "" || route.Path == "" {
+			errs = append(errs, fmt.Errorf("authz: route %d: method and path must be provided", i))
+		}
+		if route.Public == (route.Action != "") {
+			errs = append(errs, fmt.Errorf("authz: route %d: exactly one of action and public must be provided", i))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Route returns the route entry covering the method and path, which is either
+// a gin route pattern or a concrete request path such as /v1/movies/42. Like
+// gin, an exact entry wins over one matched through its parameters
+func (e *Engine) Route(method, path string) (Route, bool) {
+	if route, found := e.route(method, func(pattern string) bool { return pattern == path }); found {
+		return route, true
+	}
+
+	return e.route(method, func(pattern string) bool { return matchPath(pattern, path) })
+}
+
+func (e *Engine) route(method string, match func(pattern string) bool) (Route, bool) {
+	for _, route := range e.policy.Routes {
+		if strings.EqualFold(route.Method, method) && match(route.Path) {
+			return route, true
+		}
+	}
+
+	return Route{}, false
+}
+
+// matchPath reports whether path fits the pattern, :name segments match any
+// single segment and a trailing /* matches everything below its prefix
+func matchPath(pattern, path string) bool {
+	patternSegments := strings.Split(pattern, "/")
+	pathSegments := strings.Split(path, "/")
+
+	if last := len(patternSegments) - 1; patternSegments[last] == "*" {
+		patternSegments = patternSegments[:last]
+		if len(pathSegments) <= len(patternSegments) {
+			return false
+		}
+		pathSegments = pathSegments[:len(patternSegments)]
+	}
+
+	if len(patternSegments) != len(pathSegments) {
+		return false
+	}
+
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, ":") && pathSegments[i] != "" {
+			continue
+		}
+		if segment != pathSegments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Evaluate decides the request, a matching deny rule always wins and a request
+// no allow rule matches is denied
+func (e *Engine) Evaluate(req Request) Decision {
+	decision := Decision{
+		Reason: "no rule allows this action",
+		Trace:  make([]RuleResult, 0, len(e.policy.Rules)),
+	}
+
+	for _, rule := range e.policy.Rules {
+		reason, matched := rule.match(req)
+		decision.Trace = append(decision.Trace, RuleResult{
+			Rule:    rule.Name,
+			Effect:  rule.Effect,
+			Matched: matched,
+			Reason:  reason,
+		})
+
+		if !matched {
+			continue
+		}
+
+		switch rule.Effect {
+		case EffectDeny:
+			decision.Allowed = false
+			decision.Rule = rule.Name
+			decision.Reason = "denied by rule " + rule.Name
+			return decision
+		case EffectAllow:
+			if !decision.Allowed {
+				decision.Allowed = true
+				decision.Rule = rule.Name
+				decision.Reason = "allowed by rule " + rule.Name
+			}
+		}
+	}
+
+	return decision
+}
+
+func (r Rule) match(req Request) (string, bool) {
+	if !matchAction(r.Actions, req.Action) {
+		return "action does not match", false
+	}
+
+	s := r.Subject
+	if s.Authenticated != nil && *s.Authenticated == req.Subject.Anonymous {
+		return fmt.Sprintf("subject must have authenticated=%t", *s.Authenticated), false
+	}
+	if s.Activated != nil && *s.Activated != req.Subject.Activated {
+		return fmt.Sprintf("subject must have activated=%t", *s.Activated), false
+	}
+	if len(s.Permissions) > 0 && !hasAny(req.Subject.Permissions, s.Permissions) {
+		return "subject lacks one of permissions " + strings.Join(s.Permissions, ", "), false
+	}
+
+	res := r.Resource
+	if res.Type != "" && res.Type != req.Resource.Type {
+		return "resource type must be " + res.Type, false
+	}
+	if res.Owned && (req.Subject.Anonymous || req.Resource.Attributes["owner_id"] != strconv.FormatInt(req.Subject.ID, 10)) {
+		return "resource is not owned by subject", false
+	}
+	for key, value := range res.Attributes {
+		if req.Resource.Attributes[key] != value {
+			return fmt.Sprintf("resource attribute %s must be %q", key, value), false
+		}
+	}
+
+	return "all conditions met", true
+}
+
+// matchAction supports exact actions, "*" and prefix wildcards like "movies:*"
+func matchAction(patterns []string, action string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" || pattern == action {
+			return true
+		}
+
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(action, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasAny(held, wanted []string) bool {
+	for _, w := range wanted {
+		for _, h := range held {
+			if h == w {
+				return true
+			}
+		}
+	}
+
+	return false
+}
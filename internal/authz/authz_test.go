@@ -0,0 +1,218 @@
+package authz
+
+import "testing"
+
+func newTestEngine(t *testing.T, policy string) *Engine {
+	t.Helper()
+
+	engine, err := parse([]byte(policy))
+	if err != nil {
+		t.Fatalf("parse policy: %v", err)
+	}
+
+	return engine
+}
+
+func TestRoute(t *testing.T) {
+	engine := newTestEngine(t, `{
+		"routes": [
+			{"method": "GET", "path": "/v1/healthcheck", "public": true},
+			{"method": "GET", "path": "/v1/users/me", "action": "users:self"},
+			{"method": "GET", "path": "/v1/users/:id", "action": "users:read"},
+			{"method": "PATCH", "path": "/v1/movies/:id", "action": "movies:write"},
+			{"method": "delete", "path": "/v1/movies/:id/collaborators/:user_id", "action": "movies:write"},
+			{"method": "GET", "path": "/v1/admin/*", "action": "users:admin"}
+		]
+	}`)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		action string
+		public bool
+		found  bool
+	}{
+		{name: "exact pattern", method: "PATCH", path: "/v1/movies/:id", action: "movies:write", found: true},
+		{name: "concrete path", method: "PATCH", path: "/v1/movies/42", action: "movies:write", found: true},
+		{name: "concrete path with two parameters", method: "DELETE", path: "/v1/movies/42/collaborators/7", action: "movies:write", found: true},
+		{name: "lower case method", method: "patch", path: "/v1/movies/42", action: "movies:write", found: true},
+		{name: "lower case method in policy", method: "DELETE", path: "/v1/movies/:id/collaborators/:user_id", action: "movies:write", found: true},
+		{name: "other method", method: "GET", path: "/v1/movies/42", found: false},
+		{name: "empty parameter", method: "PATCH", path: "/v1/movies/", found: false},
+		{name: "extra segment", method: "PATCH", path: "/v1/movies/42/extra", found: false},
+		{name: "static segment wins over parameter", method: "GET", path: "/v1/users/me", action: "users:self", found: true},
+		{name: "parameter after static miss", method: "GET", path: "/v1/users/42", action: "users:read", found: true},
+		{name: "prefix covers child", method: "GET", path: "/v1/admin/users", action: "users:admin", found: true},
+		{name: "prefix covers pattern", method: "GET", path: "/v1/admin/users/:id", action: "users:admin", found: true},
+		{name: "prefix covers deep child", method: "GET", path: "/v1/admin/users/42/tokens", action: "users:admin", found: true},
+		{name: "prefix does not cover itself", method: "GET", path: "/v1/admin", found: false},
+		{name: "prefix does not cover sibling", method: "GET", path: "/v1/administrators", found: false},
+		{name: "public route", method: "GET", path: "/v1/healthcheck", public: true, found: true},
+		{name: "unlisted route", method: "GET", path: "/v1/unknown", found: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, found := engine.Route(tt.method, tt.path)
+			if found != tt.found {
+				t.Fatalf("found = %t, want %t", found, tt.found)
+			}
+			if route.Action != tt.action {
+				t.Errorf("action = %q, want %q", route.Action, tt.action)
+			}
+			if route.Public != tt.public {
+				t.Errorf("public = %t, want %t", route.Public, tt.public)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	engine := newTestEngine(t, `{
+		"rules": [
+			{
+				"name": "readers",
+				"effect": "allow",
+				"actions": ["movies:read"],
+				"subject": {"activated": true, "permissions": ["movies:read"]}
+			},
+			{
+				"name": "owners",
+				"effect": "allow",
+				"actions": ["movies:*"],
+				"subject": {"activated": true},
+				"resource": {"type": "movie", "owned": true}
+			},
+			{
+				"name": "no-locked-movies",
+				"effect": "deny",
+				"actions": ["movies:edit"],
+				"resource": {"attributes": {"locked": "true"}}
+			},
+			{
+				"name": "everyone-reads-trailers",
+				"effect": "allow",
+				"actions": ["trailers:read"]
+			}
+		]
+	}`)
+
+	reader := Subject{ID: 1, Activated: true, Permissions: []string{"movies:read"}}
+	owner := Subject{ID: 2, Activated: true}
+	inactiveOwner := Subject{ID: 2}
+
+	ownMovie := Resource{Type: "movie", Attributes: map[string]string{"owner_id": "2"}}
+	ownLockedMovie := Resource{Type: "movie", Attributes: map[string]string{"owner_id": "2", "locked": "true"}}
+
+	tests := []struct {
+		name     string
+		req      Request
+		allowed  bool
+		rule     string
+		reason   string
+		traceLen int
+	}{
+		{
+			name:     "allowed by permission",
+			req:      Request{Subject: reader, Action: "movies:read"},
+			allowed:  true,
+			rule:     "readers",
+			reason:   "allowed by rule readers",
+			traceLen: 4,
+		},
+		{
+			name:     "no matching rule",
+			req:      Request{Subject: reader, Action: "movies:edit", Resource: ownMovie},
+			reason:   "no rule allows this action",
+			traceLen: 4,
+		},
+		{
+			name:     "allowed by ownership and action wildcard",
+			req:      Request{Subject: owner, Action: "movies:edit", Resource: ownMovie},
+			allowed:  true,
+			rule:     "owners",
+			reason:   "allowed by rule owners",
+			traceLen: 4,
+		},
+		{
+			name:     "deny wins over an earlier allow",
+			req:      Request{Subject: owner, Action: "movies:edit", Resource: ownLockedMovie},
+			rule:     "no-locked-movies",
+			reason:   "denied by rule no-locked-movies",
+			traceLen: 3,
+		},
+		{
+			name:     "deny only covers its actions",
+			req:      Request{Subject: owner, Action: "movies:delete", Resource: ownLockedMovie},
+			allowed:  true,
+			rule:     "owners",
+			reason:   "allowed by rule owners",
+			traceLen: 4,
+		},
+		{
+			name:     "inactive subject",
+			req:      Request{Subject: inactiveOwner, Action: "movies:edit", Resource: ownMovie},
+			reason:   "no rule allows this action",
+			traceLen: 4,
+		},
+		{
+			name:     "anonymous never owns",
+			req:      Request{Subject: Subject{Anonymous: true, Activated: true}, Action: "movies:edit", Resource: Resource{Type: "movie", Attributes: map[string]string{"owner_id": "0"}}},
+			reason:   "no rule allows this action",
+			traceLen: 4,
+		},
+		{
+			name:     "rule without conditions",
+			req:      Request{Subject: Subject{Anonymous: true}, Action: "trailers:read"},
+			allowed:  true,
+			rule:     "everyone-reads-trailers",
+			reason:   "allowed by rule everyone-reads-trailers",
+			traceLen: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := engine.Evaluate(tt.req)
+			if decision.Allowed != tt.allowed {
+				t.Errorf("allowed = %t, want %t", decision.Allowed, tt.allowed)
+			}
+			if decision.Rule != tt.rule {
+				t.Errorf("rule = %q, want %q", decision.Rule, tt.rule)
+			}
+			if decision.Reason != tt.reason {
+				t.Errorf("reason = %q, want %q", decision.Reason, tt.reason)
+			}
+			if len(decision.Trace) != tt.traceLen {
+				t.Errorf("trace has %d entries, want %d", len(decision.Trace), tt.traceLen)
+			}
+		})
+	}
+}
+
+func TestParseRejectsInvalidRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+	}{
+		{name: "missing action", policy: `{"routes": [{"method": "GET", "path": "/v1/movies"}]}`},
+		{name: "public with action", policy: `{"routes": [{"method": "GET", "path": "/v1/movies", "action": "movies:read", "public": true}]}`},
+		{name: "missing path", policy: `{"routes": [{"method": "GET", "public": true}]}`},
+		{name: "unknown effect", policy: `{"rules": [{"name": "r", "effect": "maybe", "actions": ["*"]}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parse([]byte(tt.policy)); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	if _, err := Default(); err != nil {
+		t.Fatalf("shipped policy: %v", err)
+	}
+}
@@ -1,10 +1,11 @@
 package config
 
 import (
+	"errors"
 	"expvar"
 	"flag"
+	"fmt"
 	"runtime"
-	"strings"
 	"time"
 
 	"os"
@@ -13,10 +14,11 @@ import (
 )
 
 type Config struct {
-	Version string
-	Port    int
-	Env     string
-	DB      struct {
+	Version   string
+	Port      int
+	AdminAddr string
+	Env       string
+	DB        struct {
 		Dsn          string
 		MaxOpenConns int
 		MaxIdleConns int
@@ -24,9 +26,15 @@ type Config struct {
 	}
 
 	Limiter struct {
-		Rps    float64
-		Burst  int
-		Enable bool
+		Rps          float64
+		Burst        int
+		PreAuthRps   float64
+		PreAuthBurst int
+		Enable       bool
+		Backend      string
+		RedisURL     string
+		RedisPrefix  string
+		PolicyFile   string
 	}
 
 	Smtp struct {
@@ -38,7 +46,89 @@ type Config struct {
 	}
 
 	Cors struct {
-		TrustedOrigins []string
+		TrustedOrigins   []string
+		AllowedMethods   []string
+		AllowedHeaders   []string
+		ExposedHeaders   []string
+		MaxAge           int
+		AllowCredentials bool
+		OverridesFile    string
+	}
+
+	Authz struct {
+		PolicyFile string
+	}
+
+	TrustedProxies []string
+	// ProxyHeader is the forwarding header the trusted proxies set
+	ProxyHeader string
+
+	Tracing struct {
+		Exporter     string
+		OTLPEndpoint string
+		OTLPInsecure bool
+		SampleRatio  float64
+	}
+
+	Compression struct {
+		Enable  bool
+		MinSize int
+	}
+
+	AccessLog struct {
+		Enable       bool
+		SampleRate   float64
+		ExcludePaths []string
+	}
+
+	Idempotency struct {
+		TTL time.Duration
+	}
+
+	Server struct {
+		IdleTimeout       time.Duration
+		ReadTimeout       time.Duration
+		ReadHeaderTimeout time.Duration
+		WriteTimeout      time.Duration
+		MaxHeaderBytes    int
+		ShutdownTimeout   time.Duration
+		DrainTimeout      time.Duration
+		// ShutdownDelay keeps serving with /readyz failing before shutting down,
+		// so load balancers stop routing to the instance first
+		ShutdownDelay time.Duration
+	}
+
+	TLS struct {
+		CertFile       string
+		KeyFile        string
+		MinVersion     string
+		CipherSuites   []string
+		HTTP2          bool
+		ReloadInterval time.Duration
+		RedirectAddr   string
+		HSTS           struct {
+			MaxAge            time.Duration
+			IncludeSubdomains bool
+			Preload           bool
+		}
+	}
+
+	Health struct {
+		CheckTimeout time.Duration
+		CheckSMTP    bool
+	}
+
+	Mode struct {
+		Initial    string
+		RetryAfter time.Duration
+	}
+
+	Timeouts struct {
+		Default time.Duration
+		// Routes maps "METHOD /path" or "/path", with gin route patterns, to a deadline
+		Routes map[string]time.Duration
+		// Background bounds the tasks a request starts, such as sending emails
+		Background time.Duration
 	}
 }
 
@@ -47,40 +137,151 @@ var Logger *jsonlog.Logger
 
 const version = "1.0.0"
 
-func InitConfig() {
+// InitConfig layers the configuration: flag defaults, then the -config file,
+// then GREENLIGHT_* environment variables, then flags given on the command
+// line. Every problem found is reported at once, and -print-config dumps the
+// result with secrets redacted and exits
+func InitConfig() error {
 	Cfg.Version = version
+
+	// config the logger
+	Logger = jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+
+	configFile := flag.String("config", os.Getenv(envPrefix+"CONFIG"), "Config file (YAML or TOML), setting names match the flags")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration, secrets redacted, and exit")
+
 	// flag config
 	flag.IntVar(&Cfg.Port, "port", 4000, "API server port")
+	flag.StringVar(&Cfg.AdminAddr, "admin-addr", "127.0.0.1:4001", "Admin server listen address, serving metrics, expvar, pprof and admin tools (empty disables it)")
 	flag.StringVar(&Cfg.Env, "env", "development", "Environment (development|staging|production)")
 	flag.StringVar(&Cfg.DB.Dsn, "db-dsn", "", "PostgreSQL DSN")
 
 	// read the database configure
-	flag.IntVar(&Cfg.DB.MaxIdleConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&Cfg.DB.MaxOpenConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	flag.IntVar(&Cfg.DB.MaxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
+	flag.IntVar(&Cfg.DB.MaxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&Cfg.DB.MaxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
 
 	// read the rate limitor configure
 	flag.Float64Var(&Cfg.Limiter.Rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&Cfg.Limiter.Burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	flag.Float64Var(&Cfg.Limiter.PreAuthRps, "limiter-preauth-rps", 10, "Rate limiter requests per second per client IP, counted before authentication")
+	flag.IntVar(&Cfg.Limiter.PreAuthBurst, "limiter-preauth-burst", 20, "Rate limiter burst per client IP, counted before authentication")
 	flag.BoolVar(&Cfg.Limiter.Enable, "limiter-enabled", true, "Enable rate limiter")
+	flag.StringVar(&Cfg.Limiter.Backend, "limiter-backend", "memory", "Rate limiter backend (memory|redis)")
+	flag.StringVar(&Cfg.Limiter.RedisURL, "limiter-redis-url", "redis://localhost:6379/0", "Redis URL used by the redis rate limiter backend")
+	flag.StringVar(&Cfg.Limiter.PolicyFile, "limiter-policy-file", "", "Rate limit policy file (JSON), every client gets -limiter-rps and -limiter-burst when empty")
+	flag.StringVar(&Cfg.Limiter.RedisPrefix, "limiter-redis-prefix", "greenlight:ratelimit:", "Key prefix used by the redis rate limiter backend")
 
 	// read the email configure
-	flag.StringVar(&Cfg.Smtp.Host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
+	flag.StringVar(&Cfg.Smtp.Host, "smtp-host", "localhost", "SMTP host")
 	flag.IntVar(&Cfg.Smtp.Port, "smtp-port", 25, "SMTP port")
-	flag.StringVar(&Cfg.Smtp.Username, "smtp-username", "347b3782b87de5", "SMTP username")
-	flag.StringVar(&Cfg.Smtp.Password, "smtp-password", "c4e438ea2a35d5", "SMTP password")
+	flag.StringVar(&Cfg.Smtp.Username, "smtp-username", "", "SMTP username")
+	flag.StringVar(&Cfg.Smtp.Password, "smtp-password", "", "SMTP password")
 	flag.StringVar(&Cfg.Smtp.Sender, "smtp-sender", "Greenlight <no-reply@greenlight.fyerfyer.net>", "SMTP sender")
 
-	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
-		Cfg.Cors.TrustedOrigins = strings.Fields(val)
-		return nil
-	})
+	flag.Var(&listValue{&Cfg.Cors.TrustedOrigins}, "cors-trusted-origins", "Trusted CORS origins, exact, https://*.example.com or ~regex (space separated)")
+
+	Cfg.Cors.AllowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	flag.Var(&listValue{&Cfg.Cors.AllowedMethods}, "cors-allowed-methods", "Methods allowed in CORS requests (space separated)")
+
+	Cfg.Cors.AllowedHeaders = []string{"Authorization", "Content-Type", "Idempotency-Key", "X-Request-ID"}
+	flag.Var(&listValue{&Cfg.Cors.AllowedHeaders}, "cors-allowed-headers", "Request headers allowed in CORS requests (space separated)")
+
+	Cfg.Cors.ExposedHeaders = []string{"Location", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After", "Idempotent-Replayed", "X-Request-ID"}
+	flag.Var(&listValue{&Cfg.Cors.ExposedHeaders}, "cors-exposed-headers", "Response headers exposed to CORS requests (space separated)")
+
+	flag.IntVar(&Cfg.Cors.MaxAge, "cors-max-age", 600, "Seconds browsers may cache CORS preflight responses")
+	flag.BoolVar(&Cfg.Cors.AllowCredentials, "cors-allow-credentials", false, "Allow credentialed CORS requests")
+	flag.StringVar(&Cfg.Cors.OverridesFile, "cors-overrides-file", "", "Per-route CORS overrides file (JSON)")
+
+	flag.Var(&listValue{&Cfg.TrustedProxies}, "trusted-proxies", "Trusted proxy IPs or CIDRs whose forwarding headers are believed (space separated)")
+	flag.StringVar(&Cfg.ProxyHeader, "trusted-proxy-header", "X-Forwarded-For", "Forwarding header set by the trusted proxies, the others are ignored (Forwarded|X-Forwarded-For|X-Real-IP)")
+
+	// read the compression configure
+	flag.BoolVar(&Cfg.Compression.Enable, "compression-enabled", true, "Enable gzip, brotli and zstd response compression")
+	flag.IntVar(&Cfg.Compression.MinSize, "compression-min-size", 1024, "Minimum response size in bytes worth compressing")
+
+	// read the tracing configure
+	flag.StringVar(&Cfg.Tracing.Exporter, "tracing-exporter", "none", "Trace exporter (none|stdout|otlp)")
+	flag.StringVar(&Cfg.Tracing.OTLPEndpoint, "tracing-otlp-endpoint", "localhost:4318", "OTLP/HTTP collector host:port")
+	flag.BoolVar(&Cfg.Tracing.OTLPInsecure, "tracing-otlp-insecure", false, "Send traces to the OTLP collector over plain HTTP")
+	flag.Float64Var(&Cfg.Tracing.SampleRatio, "tracing-sample-ratio", 1, "Fraction of new traces to record (0-1)")
+
+	// read the access log configure
+	flag.BoolVar(&Cfg.AccessLog.Enable, "access-log-enabled", true, "Enable HTTP access logging")
+	flag.Float64Var(&Cfg.AccessLog.SampleRate, "access-log-sample-rate", 1, "Fraction of successful requests to log (0-1), failed requests are always logged")
+	Cfg.AccessLog.ExcludePaths = []string{"/v1/healthcheck", "/livez", "/readyz"}
+	flag.Var(&listValue{&Cfg.AccessLog.ExcludePaths}, "access-log-exclude-paths", "Request paths never access logged (space separated)")
+
+	// read the idempotency configure
+	flag.DurationVar(&Cfg.Idempotency.TTL, "idempotency-ttl", 24*time.Hour, "How long responses to requests with an Idempotency-Key are kept for replay")
+
+	// read the HTTP server configure
+	flag.DurationVar(&Cfg.Server.IdleTimeout, "server-idle-timeout", time.Minute, "How long keep-alive connections wait for the next request")
+	flag.DurationVar(&Cfg.Server.ReadTimeout, "server-read-timeout", 10*time.Second, "Deadline for reading a whole request, body included")
+	flag.DurationVar(&Cfg.Server.ReadHeaderTimeout, "server-read-header-timeout", 5*time.Second, "Deadline for reading request headers")
+	flag.DurationVar(&Cfg.Server.WriteTimeout, "server-write-timeout", 30*time.Second, "Deadline for writing a response, keep it above -request-timeout")
+	flag.IntVar(&Cfg.Server.MaxHeaderBytes, "server-max-header-bytes", 1<<20, "Maximum size of request headers in bytes")
+	flag.DurationVar(&Cfg.Server.ShutdownTimeout, "shutdown-timeout", 20*time.Second, "Deadline for in-flight requests to finish on shutdown")
+	flag.DurationVar(&Cfg.Server.ShutdownDelay, "shutdown-delay", 5*time.Second, "How long /readyz fails before the server stops accepting requests, 0 disables it")
+	flag.DurationVar(&Cfg.Server.DrainTimeout, "shutdown-drain-timeout", 30*time.Second, "Deadline for background tasks, such as emails, to finish on shutdown")
+
+	// read the TLS configure
+	flag.StringVar(&Cfg.TLS.CertFile, "tls-cert-file", "", "TLS certificate file (PEM), the API is served over HTTPS when set with -tls-key-file")
+	flag.StringVar(&Cfg.TLS.KeyFile, "tls-key-file", "", "TLS private key file (PEM)")
+	flag.StringVar(&Cfg.TLS.MinVersion, "tls-min-version", "1.2", "Minimum TLS version (1.2|1.3)")
+	flag.Var(&listValue{&Cfg.TLS.CipherSuites}, "tls-cipher-suites", "TLS 1.2 cipher suites to allow, Go's defaults when empty (space separated)")
+	flag.BoolVar(&Cfg.TLS.HTTP2, "tls-http2", true, "Enable HTTP/2 over TLS")
+	flag.DurationVar(&Cfg.TLS.ReloadInterval, "tls-reload-interval", 30*time.Second, "How often the certificate files are checked for changes")
+	flag.StringVar(&Cfg.TLS.RedirectAddr, "tls-redirect-addr", "", "Listen address redirecting plain HTTP to HTTPS, e.g. :80 (empty disables it)")
+	flag.DurationVar(&Cfg.TLS.HSTS.MaxAge, "hsts-max-age", 365*24*time.Hour, "Strict-Transport-Security max-age sent over HTTPS, 0 disables the header")
+	flag.BoolVar(&Cfg.TLS.HSTS.IncludeSubdomains, "hsts-include-subdomains", false, "Add includeSubDomains to Strict-Transport-Security")
+	flag.BoolVar(&Cfg.TLS.HSTS.Preload, "hsts-preload", false, "Add preload to Strict-Transport-Security")
+
+	// read the readiness probe configure
+	flag.DurationVar(&Cfg.Health.CheckTimeout, "health-check-timeout", 2*time.Second, "Deadline for each /readyz dependency check")
+	flag.BoolVar(&Cfg.Health.CheckSMTP, "health-check-smtp", false, "Include SMTP server reachability in /readyz")
+
+	// read the service mode configure
+	flag.StringVar(&Cfg.Mode.Initial, "mode", "normal", "Service mode to start in (normal|read_only|maintenance)")
+	flag.DurationVar(&Cfg.Mode.RetryAfter, "mode-retry-after", 5*time.Minute, "Retry-After sent with requests rejected by read-only or maintenance mode")
+
+	// read the request timeout configure
+	flag.DurationVar(&Cfg.Timeouts.Default, "request-timeout", 5*time.Second, "Deadline for handling a request, 0 disables it")
+	Cfg.Timeouts.Routes = map[string]time.Duration{}
+	flag.Var(&routeTimeoutsValue{&Cfg.Timeouts.Routes}, "route-timeouts", "Per-route deadlines as [METHOD:]/path=duration, e.g. GET:/v1/movies=10s (space separated)")
+	flag.DurationVar(&Cfg.Timeouts.Background, "background-timeout", 30*time.Second, "Deadline for a background task, such as sending an email")
+
+	// read the authorization policy configure
+	flag.StringVar(&Cfg.Authz.PolicyFile, "authz-policy-file", "", "Authorization policy file (JSON), the built-in policy is used when empty")
 
 	flag.Parse()
-	// log.Println(Cfg.DB.Dsn)
 
-	// config the logger
-	Logger = jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	// flags given on the command line win over the file and the environment
+	explicit := map[string]bool{"config": true, "print-config": true}
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	var errs []error
+	if *configFile != "" {
+		errs = append(errs, loadFile(*configFile, explicit)...)
+	}
+	errs = append(errs, loadEnv(explicit)...)
+	errs = append(errs, Validate(Cfg)...)
+
+	if *printConfig {
+		if err := printEffective(os.Stdout); err != nil {
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			fmt.Fprintln(os.Stderr, errors.Join(errs...))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	return errors.Join(errs...)
 }
 
 func ConfigExpvar() {
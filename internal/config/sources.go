@@ -0,0 +1,258 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix namespaces the environment variables, GREENLIGHT_DB_DSN sets -db-dsn
+const envPrefix = "GREENLIGHT_"
+
+// loadFile applies the settings of a YAML or TOML file to the flags not given
+// on the command line. Keys are flag names, and nested tables are joined with
+// dashes, so db: {dsn: ...} sets -db-dsn too
+func loadFile(path string, explicit map[string]bool) []error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return []error{fmt.Errorf("config file: %w", err)}
+	}
+
+	raw := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &raw)
+	case ".toml":
+		err = toml.Unmarshal(content, &raw)
+	default:
+		return []error{fmt.Errorf("config file: unsupported format %q, use .yaml, .yml or .toml", filepath.Ext(path))}
+	}
+	if err != nil {
+		return []error{fmt.Errorf("config file: %w", err)}
+	}
+
+	settings := map[string]string{}
+	flatten("", raw, settings)
+
+	var errs []error
+	for _, name := range sortedKeys(settings) {
+		f := flag.Lookup(name)
+		if f == nil || name == "config" || name == "print-config" {
+			errs = append(errs, fmt.Errorf("config file: unknown setting %q", name))
+			continue
+		}
+
+		if explicit[name] {
+			continue
+		}
+
+		if err := flag.Set(name, settings[name]); err != nil {
+			errs = append(errs, fmt.Errorf("config file: %s: %w", name, err))
+		}
+	}
+
+	return errs
+}
+
+func flatten(prefix string, raw map[string]any, settings map[string]string) {
+	for key, value := range raw {
+		name := strings.ReplaceAll(strings.ToLower(key), "_", "-")
+		if prefix != "" {
+			name = prefix + "-" + name
+		}
+
+		switch value := value.(type) {
+		case map[string]any:
+			flatten(name, value, settings)
+		case []any:
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			settings[name] = strings.Join(items, " ")
+		case nil:
+			settings[name] = ""
+		default:
+			settings[name] = fmt.Sprint(value)
+		}
+	}
+}
+
+// loadEnv applies GREENLIGHT_* variables to the flags not given on the command
+// line. NAME_FILE reads the value from a file instead, for mounted secrets
+func loadEnv(explicit map[string]bool) []error {
+	var errs []error
+
+	flag.VisitAll(func(f *flag.Flag) {
+		if explicit[f.Name] {
+			return
+		}
+
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value, found := os.LookupEnv(name)
+
+		if path, fromFile := os.LookupEnv(name + "_FILE"); fromFile {
+			if found {
+				errs = append(errs, fmt.Errorf("%s: set only one of %s and %s_FILE", f.Name, name, name))
+				return
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s_FILE: %w", name, err))
+				return
+			}
+			value, found = strings.TrimRight(string(content), "\r\n"), true
+		}
+
+		if !found {
+			return
+		}
+
+		if err := flag.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	})
+
+	return errs
+}
+
+// dsnPasswordRX matches the password of a key=value DSN, quoted values may
+// hold spaces and backslash escaped quotes
+var dsnPasswordRX = regexp.MustCompile(`password\s*=\s*('(?:[^'\\]|\\.)*'|\S+)`)
+
+// secrets redact the settings that hold credentials before they are printed
+var secrets = map[string]func(string) string{
+	"db-dsn":            redactDSN,
+	"limiter-redis-url": redactURL,
+	"smtp-password": func(value string) string {
+		if value == "" {
+			return ""
+		}
+		return "[redacted]"
+	},
+}
+
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+
+	return u.Redacted()
+}
+
+// redactDSN handles both the URL and the key=value forms of a PostgreSQL DSN
+func redactDSN(value string) string {
+	if strings.Contains(value, "://") {
+		return redactURL(value)
+	}
+
+	return dsnPasswordRX.ReplaceAllString(value, "password=xxxxx")
+}
+
+// printEffective writes every setting as YAML, in a form -config accepts back
+func printEffective(w io.Writer) error {
+	settings := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "print-config" {
+			return
+		}
+
+		value := f.Value.String()
+		if redact, found := secrets[f.Name]; found {
+			value = redact(value)
+		}
+		settings[f.Name] = value
+	})
+
+	out, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(out)
+	return err
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// listValue is a flag holding a space separated list
+type listValue struct {
+	list *[]string
+}
+
+func (v *listValue) String() string {
+	if v.list == nil {
+		return ""
+	}
+
+	return strings.Join(*v.list, " ")
+}
+
+func (v *listValue) Set(val string) error {
+	*v.list = strings.Fields(val)
+	return nil
+}
+
+// routeTimeoutsValue is the -route-timeouts flag, entries are
+// [METHOD:]/path=duration and are stored keyed by "METHOD /path" or "/path"
+type routeTimeoutsValue struct {
+	routes *map[string]time.Duration
+}
+
+func (v *routeTimeoutsValue) String() string {
+	if v.routes == nil {
+		return ""
+	}
+
+	entries := make([]string, 0, len(*v.routes))
+	for _, route := range sortedKeys(*v.routes) {
+		entries = append(entries, strings.Replace(route, " ", ":", 1)+"="+(*v.routes)[route].String())
+	}
+
+	return strings.Join(entries, " ")
+}
+
+func (v *routeTimeoutsValue) Set(val string) error {
+	routes := map[string]time.Duration{}
+
+	for _, entry := range strings.Fields(val) {
+		route, value, found := strings.Cut(entry, "=")
+		if !found {
+			return fmt.Errorf("invalid route timeout %q", entry)
+		}
+
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid route timeout %q: %w", entry, err)
+		}
+
+		// paths start with a slash, so a colon before one separates the method
+		if method, path, found := strings.Cut(route, ":"); found && !strings.HasPrefix(route, "/") {
+			route = strings.ToUpper(method) + " " + path
+		}
+		routes[route] = timeout
+	}
+
+	*v.routes = routes
+	return nil
+}
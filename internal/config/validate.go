@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// Validate checks the settings make sense together, returning every problem
+// rather than stopping at the first so a deployment can be fixed in one go
+func Validate(c Config) []error {
+	var errs []error
+	check := func(ok bool, setting, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: "+format, append([]any{setting}, args...)...))
+		}
+	}
+	oneOf := func(value, setting string, permitted ...string) {
+		check(slices.Contains(permitted, value), setting, "must be one of %v, got %q", permitted, value)
+	}
+	positive := func(d time.Duration, setting string) {
+		check(d > 0, setting, "must be greater than zero")
+	}
+	notNegative := func(d time.Duration, setting string) {
+		check(d >= 0, setting, "must not be negative")
+	}
+
+	check(c.Port > 0 && c.Port <= 65535, "port", "must be between 1 and 65535")
+	oneOf(c.Env, "env", "development", "staging", "production")
+
+	check(c.DB.Dsn != "", "db-dsn", "must be provided")
+	check(c.DB.MaxOpenConns >= 0, "db-max-open-conns", "must not be negative")
+	check(c.DB.MaxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+	_, err := time.ParseDuration(c.DB.MaxIdleTime)
+	check(err == nil, "db-max-idle-time", "must be a duration such as 15m")
+
+	oneOf(c.Limiter.Backend, "limiter-backend", "memory", "redis")
+	if c.Limiter.Enable {
+		check(c.Limiter.Rps > 0, "limiter-rps", "must be greater than zero")
+		check(c.Limiter.Burst > 0, "limiter-burst", "must be greater than zero")
+		check(c.Limiter.PreAuthRps > 0, "limiter-preauth-rps", "must be greater than zero")
+		check(c.Limiter.PreAuthBurst > 0, "limiter-preauth-burst", "must be greater than zero")
+	}
+	if c.Limiter.Backend == "redis" {
+		u, err := url.Parse(c.Limiter.RedisURL)
+		check(err == nil && (u.Scheme == "redis" || u.Scheme == "rediss") && u.Host != "", "limiter-redis-url", "must be a redis:// or rediss:// URL")
+	}
+
+	check(c.Smtp.Host != "", "smtp-host", "must be provided")
+	check(c.Smtp.Port > 0 && c.Smtp.Port <= 65535, "smtp-port", "must be between 1 and 65535")
+	check(c.Smtp.Username == "" || c.Smtp.Password != "", "smtp-password", "must be provided with smtp-username")
+	_, err = mail.ParseAddress(c.Smtp.Sender)
+	check(err == nil, "smtp-sender", "must be an email address such as Greenlight <no-reply@example.com>")
+
+	check(c.Cors.MaxAge >= 0, "cors-max-age", "must not be negative")
+	oneOf(c.ProxyHeader, "trusted-proxy-header", "Forwarded", "X-Forwarded-For", "X-Real-IP")
+	check(c.Compression.MinSize >= 0, "compression-min-size", "must not be negative")
+
+	oneOf(c.Tracing.Exporter, "tracing-exporter", "none", "stdout", "otlp")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing-sample-ratio", "must be between 0 and 1")
+	check(c.AccessLog.SampleRate >= 0 && c.AccessLog.SampleRate <= 1, "access-log-sample-rate", "must be between 0 and 1")
+
+	positive(c.Idempotency.TTL, "idempotency-ttl")
+
+	notNegative(c.Server.IdleTimeout, "server-idle-timeout")
+	notNegative(c.Server.ReadTimeout, "server-read-timeout")
+	notNegative(c.Server.ReadHeaderTimeout, "server-read-header-timeout")
+	notNegative(c.Server.WriteTimeout, "server-write-timeout")
+	check(c.Server.MaxHeaderBytes > 0, "server-max-header-bytes", "must be greater than zero")
+	positive(c.Server.ShutdownTimeout, "shutdown-timeout")
+	positive(c.Server.DrainTimeout, "shutdown-drain-timeout")
+	notNegative(c.Server.ShutdownDelay, "shutdown-delay")
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls-key-file", "must be set together with tls-cert-file")
+	oneOf(c.TLS.MinVersion, "tls-min-version", "1.2", "1.3")
+	positive(c.TLS.ReloadInterval, "tls-reload-interval")
+	check(c.TLS.RedirectAddr == "" || c.TLS.CertFile != "", "tls-redirect-addr", "requires tls-cert-file and tls-key-file")
+	notNegative(c.TLS.HSTS.MaxAge, "hsts-max-age")
+
+	positive(c.Health.CheckTimeout, "health-check-timeout")
+
+	oneOf(c.Mode.Initial, "mode", "normal", "read_only", "maintenance")
+	notNegative(c.Mode.RetryAfter, "mode-retry-after")
+
+	notNegative(c.Timeouts.Default, "request-timeout")
+	for _, route := range sortedKeys(c.Timeouts.Routes) {
+		check(c.Timeouts.Routes[route] >= 0, "route-timeouts", "%s must not be negative", route)
+	}
+
+	positive(c.Timeouts.Background, "background-timeout")
+
+	return errs
+}
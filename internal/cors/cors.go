@@ -0,0 +1,205 @@
+package cors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Policy describes what cross-origin requests are allowed.
+// Origins may be exact ("https://example.com"), subdomain wildcards
+// ("https://*.example.com"), regular expressions prefixed with "~"
+// ("~https://pr-[0-9]+\.example\.com"), which must match the whole origin,
+// or "*" for any origin
+type Policy struct {
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowedMethods   []string `json:"allowed_methods"`
+	AllowedHeaders   []string `json:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers"`
+	MaxAge           int      `json:"max_age"`
+	AllowCredentials bool     `json:"allow_credentials"`
+
+	matchers []func(string) bool
+}
+
+// Override replaces the fields it sets of the default policy for every
+// request path starting with Prefix
+type Override struct {
+	Prefix           string   `json:"prefix"`
+	AllowedOrigins   []string `json:"allowed_origins,omitempty"`
+	AllowedMethods   []string `json:"allowed_methods,omitempty"`
+	AllowedHeaders   []string `json:"allowed_headers,omitempty"`
+	ExposedHeaders   []string `json:"exposed_headers,omitempty"`
+	MaxAge           *int     `json:"max_age,omitempty"`
+	AllowCredentials *bool    `json:"allow_credentials,omitempty"`
+}
+
+type Config struct {
+	routes []routePolicy
+	base   *Policy
+}
+
+type routePolicy struct {
+	prefix string
+	policy *Policy
+}
+
+// New compiles the default policy together with the overrides found in the
+// file at overridesPath, if any
+func New(base Policy, overridesPath string) (*Config, error) {
+	var overrides []Override
+	if overridesPath != "" {
+		content, err := os.ReadFile(overridesPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(content, &overrides); err != nil {
+			return nil, fmt.Errorf("cors: invalid overrides: %w", err)
+		}
+	}
+
+	if err := base.compile(); err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{base: &base}
+	var errs []error
+	for i, o := range overrides {
+		if o.Prefix == "" {
+			errs = append(errs, fmt.Errorf("cors: override %d: prefix must be provided", i))
+			continue
+		}
+
+		policy := base.merge(o)
+		if err := policy.compile(); err != nil {
+			errs = append(errs, fmt.Errorf("cors: override %q: %w", o.Prefix, err))
+			continue
+		}
+
+		cfg.routes = append(cfg.routes, routePolicy{prefix: o.Prefix, policy: &policy})
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// For returns the policy applying to the request path, the first matching override wins
+func (c *Config) For(path string) *Policy {
+	for _, route := range c.routes {
+		if strings.HasPrefix(path, route.prefix) {
+			return route.policy
+		}
+	}
+
+	return c.base
+}
+
+func (p Policy) merge(o Override) Policy {
+	merged := Policy{
+		AllowedOrigins:   p.AllowedOrigins,
+		AllowedMethods:   p.AllowedMethods,
+		AllowedHeaders:   p.AllowedHeaders,
+		ExposedHeaders:   p.ExposedHeaders,
+		MaxAge:           p.MaxAge,
+		AllowCredentials: p.AllowCredentials,
+	}
+
+	if o.AllowedOrigins != nil {
+		merged.AllowedOrigins = o.AllowedOrigins
+	}
+	if o.AllowedMethods != nil {
+		merged.AllowedMethods = o.AllowedMethods
+	}
+	if o.AllowedHeaders != nil {
+		merged.AllowedHeaders = o.AllowedHeaders
+	}
+	if o.ExposedHeaders != nil {
+		merged.ExposedHeaders = o.ExposedHeaders
+	}
+	if o.MaxAge != nil {
+		merged.MaxAge = *o.MaxAge
+	}
+	if o.AllowCredentials != nil {
+		merged.AllowCredentials = *o.AllowCredentials
+	}
+
+	return merged
+}
+
+var subdomainRX = regexp.MustCompile(`^[a-z0-9-]+(\.[a-z0-9-]+)*$`)
+
+func (p *Policy) compile() error {
+	p.matchers = nil
+	for _, origin := range p.AllowedOrigins {
+		switch {
+		case origin == "*":
+			// browsers refuse credentialed responses to a wildcard, and echoing
+			// every origin back instead would hand any site the user's session
+			if p.AllowCredentials {
+				return errors.New("cors: the \"*\" origin cannot be combined with credentials")
+			}
+			p.matchers = append(p.matchers, func(string) bool { return true })
+
+		case strings.HasPrefix(origin, "~"):
+			// anchored, so a pattern can't be satisfied by a longer origin
+			// such as https://pr-1.example.com.evil.com
+			rx, err := regexp.Compile(`^(?:` + origin[1:] + `)$`)
+			if err != nil {
+				return fmt.Errorf("cors: invalid origin pattern %q: %w", origin, err)
+			}
+			p.matchers = append(p.matchers, rx.MatchString)
+
+		case strings.Contains(origin, "://*."):
+			scheme, domain, _ := strings.Cut(origin, "://*.")
+			suffix := "." + strings.ToLower(domain)
+			prefix := strings.ToLower(scheme) + "://"
+			p.matchers = append(p.matchers, func(o string) bool {
+				o = strings.ToLower(o)
+				if !strings.HasPrefix(o, prefix) || !strings.HasSuffix(o, suffix) || len(o) <= len(prefix)+len(suffix) {
+					return false
+				}
+
+				// the wildcard only stands for subdomain labels
+				return subdomainRX.MatchString(o[len(prefix) : len(o)-len(suffix)])
+			})
+
+		default:
+			exact := origin
+			p.matchers = append(p.matchers, func(o string) bool { return strings.EqualFold(o, exact) })
+		}
+	}
+
+	if p.MaxAge < 0 {
+		return errors.New("cors: max age must not be negative")
+	}
+
+	return nil
+}
+
+func (p *Policy) AllowsOrigin(origin string) bool {
+	for _, match := range p.matchers {
+		if match(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsAnyOrigin reports whether the policy was configured with "*"
+func (p *Policy) AllowsAnyOrigin() bool {
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			return true
+		}
+	}
+
+	return false
+}
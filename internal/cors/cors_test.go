@@ -0,0 +1,76 @@
+package cors
+
+import "testing"
+
+func TestAllowsOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{name: "exact", allowed: []string{"https://example.com"}, origin: "https://example.com", want: true},
+		{name: "exact ignores case", allowed: []string{"https://example.com"}, origin: "HTTPS://Example.com", want: true},
+		{name: "exact other host", allowed: []string{"https://example.com"}, origin: "https://example.org"},
+		{name: "exact other scheme", allowed: []string{"https://example.com"}, origin: "http://example.com"},
+		{name: "exact other port", allowed: []string{"https://example.com"}, origin: "https://example.com:8443"},
+
+		{name: "wildcard subdomain", allowed: []string{"https://*.example.com"}, origin: "https://a.example.com", want: true},
+		{name: "wildcard nested subdomain", allowed: []string{"https://*.example.com"}, origin: "https://a.b.example.com", want: true},
+		{name: "wildcard ignores case", allowed: []string{"https://*.Example.com"}, origin: "https://A.example.COM", want: true},
+		{name: "wildcard apex", allowed: []string{"https://*.example.com"}, origin: "https://example.com"},
+		{name: "wildcard empty label", allowed: []string{"https://*.example.com"}, origin: "https://.example.com"},
+		{name: "wildcard empty inner label", allowed: []string{"https://*.example.com"}, origin: "https://a..example.com"},
+		{name: "wildcard suffix attack", allowed: []string{"https://*.example.com"}, origin: "https://a.example.com.evil.com"},
+		{name: "wildcard lookalike", allowed: []string{"https://*.example.com"}, origin: "https://evilexample.com"},
+		{name: "wildcard path smuggling", allowed: []string{"https://*.example.com"}, origin: "https://evil.com/.example.com"},
+		{name: "wildcard userinfo smuggling", allowed: []string{"https://*.example.com"}, origin: "https://evil.com@a.example.com"},
+		{name: "wildcard other scheme", allowed: []string{"https://*.example.com"}, origin: "http://a.example.com"},
+		{name: "wildcard other port", allowed: []string{"https://*.example.com"}, origin: "https://a.example.com:8443"},
+		{name: "wildcard with port", allowed: []string{"https://*.example.com:8443"}, origin: "https://a.example.com:8443", want: true},
+		{name: "wildcard with port missing", allowed: []string{"https://*.example.com:8443"}, origin: "https://a.example.com"},
+
+		{name: "regex", allowed: []string{`~https://pr-[0-9]+\.example\.com`}, origin: "https://pr-42.example.com", want: true},
+		{name: "regex anchored at the end", allowed: []string{`~https://pr-[0-9]+\.example\.com`}, origin: "https://pr-42.example.com.evil.com"},
+		{name: "regex anchored at the start", allowed: []string{`~https://pr-[0-9]+\.example\.com`}, origin: "https://evil.com/https://pr-42.example.com"},
+		{name: "regex explicitly anchored", allowed: []string{`~^https://pr-[0-9]+\.example\.com$`}, origin: "https://pr-42.example.com", want: true},
+		{name: "regex alternation stays anchored", allowed: []string{`~https://a\.example\.com|https://b\.example\.com`}, origin: "https://b.example.com.evil.com"},
+		{name: "regex alternation", allowed: []string{`~https://a\.example\.com|https://b\.example\.com`}, origin: "https://b.example.com", want: true},
+
+		{name: "any origin", allowed: []string{"*"}, origin: "https://anything.test", want: true},
+		{name: "no origins", allowed: nil, origin: "https://example.com"},
+		{name: "second pattern", allowed: []string{"https://example.com", "https://*.example.org"}, origin: "https://a.example.org", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := New(Policy{AllowedOrigins: tt.allowed}, "")
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+
+			if got := cfg.For("/v1/movies").AllowsOrigin(tt.origin); got != tt.want {
+				t.Errorf("AllowsOrigin(%q) = %t, want %t", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRejectsInvalidPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+	}{
+		{name: "any origin with credentials", policy: Policy{AllowedOrigins: []string{"*"}, AllowCredentials: true}},
+		{name: "invalid regex", policy: Policy{AllowedOrigins: []string{"~https://(example.com"}}},
+		{name: "negative max age", policy: Policy{MaxAge: -1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.policy, ""); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
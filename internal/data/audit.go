@@ -0,0 +1,22 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// AuditLog records an action an operator took against another record,
+// so changes made through the admin API can be traced back to someone
+type AuditLog struct {
+	ID         int64             `gorm:"primaryKey" json:"id"`
+	CreatedAt  time.Time         `gorm:"not null;default:now()" json:"created_at"`
+	ActorID    int64             `gorm:"not null;index" json:"actor_id"`
+	Action     string            `gorm:"type:text;not null" json:"action"`
+	TargetType string            `gorm:"type:text;not null" json:"target_type"`
+	TargetID   int64             `gorm:"not null;index" json:"target_id"`
+	Details    map[string]string `gorm:"type:jsonb;serializer:json" json:"details,omitempty"`
+}
+
+func (a *AuditLog) Insert(ctx context.Context, entry *AuditLog) error {
+	return db.WithContext(ctx).Create(entry).Error
+}
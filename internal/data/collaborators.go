@@ -0,0 +1,65 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrDuplicateCollaborator = errors.New("duplicate collaborator")
+
+// MovieCollaborator grants a user the right to edit a movie they don't own
+type MovieCollaborator struct {
+	MovieID   int64     `gorm:"primaryKey" json:"movie_id"`
+	UserID    int64     `gorm:"primaryKey" json:"user_id"`
+	CreatedAt time.Time `gorm:"not null;default:now()" json:"created_at"`
+}
+
+func (m *MovieCollaborator) Insert(ctx context.Context, collaborator *MovieCollaborator) error {
+	if err := db.WithContext(ctx).Create(collaborator).Error; err != nil {
+		switch {
+		case strings.Contains(err.Error(), "(SQLSTATE 23505)"):
+			return ErrDuplicateCollaborator
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *MovieCollaborator) Delete(ctx context.Context, movieID, userID int64) error {
+	result := db.WithContext(ctx).
+		Where("movie_id = ? AND user_id = ?", movieID, userID).
+		Delete(&MovieCollaborator{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m *MovieCollaborator) Exists(ctx context.Context, movieID, userID int64) (bool, error) {
+	var count int64
+	err := db.WithContext(ctx).
+		Model(&MovieCollaborator{}).
+		Where("movie_id = ? AND user_id = ?", movieID, userID).
+		Count(&count).Error
+
+	return count > 0, err
+}
+
+func (m *MovieCollaborator) GetAllForMovie(ctx context.Context, movieID int64) ([]*MovieCollaborator, error) {
+	collaborators := []*MovieCollaborator{}
+	err := db.WithContext(ctx).
+		Where("movie_id = ?", movieID).
+		Order("created_at ASC").
+		Find(&collaborators).Error
+
+	return collaborators, err
+}
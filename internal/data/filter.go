@@ -45,12 +45,12 @@ func (f Filters) offset() int {
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
-	v.Check(f.Page > 0, "page", "must be greater than 0")
-	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
-	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.Check(f.Page > 0, "page", "greater_than", 0)
+	v.Check(f.Page <= 10_000_000, "page", "max_page")
+	v.Check(f.PageSize > 0, "page_size", "greater_than", 0)
+	v.Check(f.PageSize <= 100, "page_size", "maximum", 100)
 	// Check that the sort parameter matches a value in the safelist.
-	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid_sort")
 }
 
 func (f Filters) sortColumn() string {
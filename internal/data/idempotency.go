@@ -0,0 +1,82 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrDuplicateIdempotencyKey = errors.New("duplicate idempotency key")
+
+// IdempotencyKey remembers the response to a request sent with an
+// Idempotency-Key header so a retry gets the same answer instead of
+// repeating the side effects. Status is zero while the first request
+// is still being processed
+type IdempotencyKey struct {
+	Key         string            `gorm:"primaryKey;type:text"`
+	UserID      int64             `gorm:"primaryKey"`
+	Scope       string            `gorm:"primaryKey;type:text"`
+	Fingerprint string            `gorm:"type:text;not null"`
+	Status      int               `gorm:"not null;default:0"`
+	Headers     map[string]string `gorm:"type:jsonb;serializer:json"`
+	Body        []byte            `gorm:"type:bytea"`
+	CreatedAt   time.Time         `gorm:"not null;default:now()"`
+	ExpiresAt   time.Time         `gorm:"not null;index"`
+}
+
+func (i *IdempotencyKey) Get(ctx context.Context, key string, userID int64, scope string) (*IdempotencyKey, error) {
+	var record IdempotencyKey
+	result := db.WithContext(ctx).
+		Where("key = ? AND user_id = ? AND scope = ? AND expires_at > ?", key, userID, scope, time.Now()).
+		Limit(1).
+		Find(&record)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return &record, nil
+}
+
+// Insert claims the key for a new request, clearing out expired keys first
+// so they can be reused and don't pile up
+func (i *IdempotencyKey) Insert(ctx context.Context, record *IdempotencyKey) error {
+	err := db.WithContext(ctx).Where("expires_at <= ?", time.Now()).Delete(&IdempotencyKey{}).Error
+	if err != nil {
+		return err
+	}
+
+	if err := db.WithContext(ctx).Create(record).Error; err != nil {
+		switch {
+		case strings.Contains(err.Error(), "(SQLSTATE 23505)"):
+			return ErrDuplicateIdempotencyKey
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Complete stores the response of the request holding the key
+func (i *IdempotencyKey) Complete(ctx context.Context, record *IdempotencyKey) error {
+	return db.WithContext(ctx).
+		Model(&IdempotencyKey{}).
+		Where("key = ? AND user_id = ? AND scope = ?", record.Key, record.UserID, record.Scope).
+		Updates(map[string]any{
+			"status":  record.Status,
+			"headers": record.Headers,
+			"body":    record.Body,
+		}).Error
+}
+
+// Delete releases the key, so a request that failed can be retried with it
+func (i *IdempotencyKey) Delete(ctx context.Context, record *IdempotencyKey) error {
+	return db.WithContext(ctx).
+		Where("key = ? AND user_id = ? AND scope = ?", record.Key, record.UserID, record.Scope).
+		Delete(&IdempotencyKey{}).Error
+}
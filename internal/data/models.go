@@ -1,9 +1,11 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"expvar"
+	"fmt"
 	"log"
 	"time"
 
@@ -36,24 +38,45 @@ type PermissionModels struct {
 	Permissions *Permission
 }
 
+type MovieCollaboratorModels struct {
+	Collaborators *MovieCollaborator
+}
+
+type AuditLogModels struct {
+	AuditLogs *AuditLog
+}
+
+type IdempotencyKeyModels struct {
+	IdempotencyKeys *IdempotencyKey
+}
+
 type Models struct {
-	MovieModel      MovieModels
-	UserModel       UserModels
-	TokenModel      TokenModels
-	PermissionModel PermissionModels
+	MovieModel             MovieModels
+	MovieCollaboratorModel MovieCollaboratorModels
+	UserModel              UserModels
+	TokenModel             TokenModels
+	PermissionModel        PermissionModels
+	AuditLogModel          AuditLogModels
+	IdempotencyKeyModel    IdempotencyKeyModels
 }
 
 func NewModels() Models {
 	return Models{
-		MovieModel:      MovieModels{Movies: &Movie{}},
-		UserModel:       UserModels{Users: &User{}},
-		TokenModel:      TokenModels{Tokens: &Token{}},
-		PermissionModel: PermissionModels{Permissions: &Permission{}},
+		MovieModel:             MovieModels{Movies: &Movie{}},
+		MovieCollaboratorModel: MovieCollaboratorModels{Collaborators: &MovieCollaborator{}},
+		UserModel:              UserModels{Users: &User{}},
+		TokenModel:             TokenModels{Tokens: &Token{}},
+		PermissionModel:        PermissionModels{Permissions: &Permission{}},
+		AuditLogModel:          AuditLogModels{AuditLogs: &AuditLog{}},
+		IdempotencyKeyModel:    IdempotencyKeyModels{IdempotencyKeys: &IdempotencyKey{}},
 	}
 }
 
+// schema lists the models migrateModels creates tables for
+var schema = []any{&Movie{}, &User{}, &Token{}, &Permission{}, &AuditLog{}, &MovieCollaborator{}, &IdempotencyKey{}}
+
 func migrateModels(db *gorm.DB) error {
-	err := db.AutoMigrate(&Movie{}, &User{}, &Token{}, &Permission{})
+	err := db.AutoMigrate(schema...)
 	if err != nil {
 		return err
 	}
@@ -67,6 +90,37 @@ func migrateModels(db *gorm.DB) error {
 	return nil
 }
 
+// DB returns the connection pool opened by InitSql
+func DB() *sql.DB {
+	return sqlDB
+}
+
+// Ping checks a connection to the database can be used
+func Ping(ctx context.Context) error {
+	if sqlDB == nil {
+		return errors.New("database not initialized")
+	}
+
+	return sqlDB.PingContext(ctx)
+}
+
+// CheckMigrations reports the first model whose table is missing, e.g. because
+// the database was restored from a dump predating it
+func CheckMigrations(ctx context.Context) error {
+	if db == nil {
+		return errors.New("database not initialized")
+	}
+
+	migrator := db.WithContext(ctx).Migrator()
+	for _, model := range schema {
+		if !migrator.HasTable(model) {
+			return fmt.Errorf("missing table for %T", model)
+		}
+	}
+
+	return nil
+}
+
 func InitSql() error {
 	var err error
 	db, err = gorm.Open(postgres.Open(config.Cfg.DB.Dsn), &gorm.Config{})
@@ -78,6 +132,11 @@ func InitSql() error {
 	if err != nil {
 		return err
 	}
+
+	err = registerTracing(db)
+	if err != nil {
+		return err
+	}
 	err = migrateModels(db)
 	if err != nil {
 		log.Fatalf("failed to migrate database: %v", err)
@@ -15,6 +15,9 @@ import (
 	"greenlight.fyerfyer.net/internal/validator"
 )
 
+// Movie is a catalogue entry, CreatedBy is 0 for movies added before ownership
+// was tracked: nobody owns those, so only editors holding movies:manage can
+// manage them
 type Movie struct {
 	ID        int64                  `gorm:"primaryKey" json:"id"`
 	CreatedAt time.Time              `gorm:"not null;default:now()" json:"-"`
@@ -22,22 +25,23 @@ type Movie struct {
 	Year      int                    `gorm:"not null" json:"year,omitempty"`
 	Runtime   Runtime                `gorm:"not null" json:"runtime,omitempty"`
 	Genres    pq.StringArray         `gorm:"type:text[];not null" json:"genres,omitempty"`
+	CreatedBy int64                  `gorm:"not null;default:0;index" json:"created_by,omitempty"`
 	Version   optimisticlock.Version `gorm:"version" json:"version"`
 	// mux       sync.Mutex
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
-	v.Check(movie.Title != "", "title", "must be provided")
-	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
-	v.Check(movie.Year != 0, "year", "must be provided")
-	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
-	v.Check(movie.Year <= int(time.Now().Year()), "year", "must not be in the future")
-	v.Check(movie.Runtime != 0, "runtime", "must be provided")
-	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
-	v.Check(movie.Genres != nil, "genres", "must be provided")
-	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
-	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
-	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+	v.Check(movie.Title != "", "title", "required")
+	v.Check(len(movie.Title) <= 500, "title", "max_bytes", 500)
+	v.Check(movie.Year != 0, "year", "required")
+	v.Check(movie.Year >= 1888, "year", "greater_than", 1888)
+	v.Check(movie.Year <= int(time.Now().Year()), "year", "in_future")
+	v.Check(movie.Runtime != 0, "runtime", "required")
+	v.Check(movie.Runtime > 0, "runtime", "positive_integer")
+	v.Check(movie.Genres != nil, "genres", "required")
+	v.Check(len(movie.Genres) >= 1, "genres", "min_genres", 1)
+	v.Check(len(movie.Genres) <= 5, "genres", "max_genres", 5)
+	v.Check(validator.Unique(movie.Genres), "genres", "duplicate_values")
 }
 
 func (m *Movie) BeforeCreate(tx *gorm.DB) (err error) {
@@ -47,10 +51,7 @@ func (m *Movie) BeforeCreate(tx *gorm.DB) (err error) {
 	return nil
 }
 
-func (m *Movie) Insert(movie *Movie) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
+func (m *Movie) Insert(ctx context.Context, movie *Movie) error {
 	if err := db.WithContext(ctx).Create(&movie).Error; err != nil {
 		return err
 	}
@@ -58,14 +59,11 @@ func (m *Movie) Insert(movie *Movie) error {
 	return nil
 }
 
-func (m *Movie) Get(id int64) (*Movie, error) {
+func (m *Movie) Get(ctx context.Context, id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
 	var movie Movie
 
 	if err := db.WithContext(ctx).Where("id = ?", id).First(&movie).Error; err != nil {
@@ -78,10 +76,7 @@ func (m *Movie) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m *Movie) Update(movie *Movie) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
+func (m *Movie) Update(ctx context.Context, movie *Movie) error {
 	err := db.WithContext(ctx).
 		Model(&Movie{}).
 		Where("id = ?", movie.ID).
@@ -95,28 +90,26 @@ func (m *Movie) Update(movie *Movie) error {
 	return err
 }
 
-func (m *Movie) Delete(id int64) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+func (m *Movie) Delete(ctx context.Context, id int64) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("movie_id = ?", id).Delete(&MovieCollaborator{}).Error; err != nil {
+			return err
+		}
 
-	result := db.WithContext(ctx).
-		Where("id = ?", id).
-		Delete(&Movie{})
-	if result.Error != nil {
-		return result.Error
-	}
+		result := tx.Where("id = ?", id).Delete(&Movie{})
+		if result.Error != nil {
+			return result.Error
+		}
 
-	if result.RowsAffected == 0 {
-		return ErrRecordNotFound
-	}
+		if result.RowsAffected == 0 {
+			return ErrRecordNotFound
+		}
 
-	return nil
+		return nil
+	})
 }
 
-func (m *Movie) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
+func (m *Movie) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
 	var movies []*Movie
 	var totalRecord int64
 
@@ -2,7 +2,6 @@ package data
 
 import (
 	"context"
-	"time"
 )
 
 type Permission struct {
@@ -17,6 +16,8 @@ func initPermissionTable() error {
 	permissions := []*Permission{
 		{Code: "movies:read"},
 		{Code: "movies:write"},
+		{Code: "movies:manage"},
+		{Code: "users:admin"},
 	}
 	return db.Create(permissions).Error
 }
@@ -30,10 +31,7 @@ func (p Permissions) Include(code string) bool {
 	return false
 }
 
-func (p *Permission) GetAllForUser(id int64) (Permissions, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
+func (p *Permission) GetAllForUser(ctx context.Context, id int64) (Permissions, error) {
 	var codes []string
 	err := db.WithContext(ctx).
 		Model(&Permission{}).
@@ -50,15 +48,12 @@ func (p *Permission) GetAllForUser(id int64) (Permissions, error) {
 	return Permissions(codes), nil
 }
 
-func (p *Permission) AddForUser(userID int64, codes ...string) error {
+func (p *Permission) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	type UserPermission struct {
 		UserID       int64 `gorm:"column:user_id"`
 		PermissionID int64 `gorm:"column:permission_id"`
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
 	var permissionID int64
 	err := db.WithContext(ctx).
 		Model(&Permission{}).
@@ -74,7 +69,8 @@ func (p *Permission) AddForUser(userID int64, codes ...string) error {
 		PermissionID: permissionID,
 	}
 
-	err = db.Table("users_permissions").
+	err = db.WithContext(ctx).
+		Table("users_permissions").
 		Create(&userPermission).Error
 	return err
 }
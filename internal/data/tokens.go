@@ -5,8 +5,10 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
+	"errors"
 	"time"
 
+	"gorm.io/gorm"
 	"greenlight.fyerfyer.net/internal/validator"
 )
 
@@ -22,6 +24,7 @@ type Token struct {
 	UserID    int64     `gorm:"not null;constraint:OnDelete:CASCADE;foreignKey:ID;" json:"-"`
 	Expiry    time.Time `gorm:"not null" json:"expiry"`
 	Scope     string    `gorm:"not null" json:"-"`
+	ClientIP  string    `gorm:"type:text;not null;default:''" json:"-"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -48,24 +51,29 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 }
 
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
-	v.Check(tokenPlaintext != "", "token", "must be provided")
-	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+	v.Check(tokenPlaintext != "", "token", "required")
+	v.Check(len(tokenPlaintext) == 26, "token", "exact_bytes", 26)
 }
 
-func (t *Token) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (t *Token) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	return t.NewForClient(ctx, userID, ttl, scope, "")
+}
+
+// NewForClient is New for tokens that start a session, recording the address
+// of the client the session was started from
+func (t *Token) NewForClient(ctx context.Context, userID int64, ttl time.Duration, scope, clientIP string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = t.Insert(token)
+	token.ClientIP = clientIP
+
+	err = t.Insert(ctx, token)
 	return token, err
 }
 
-func (t *Token) Insert(token *Token) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
+func (t *Token) Insert(ctx context.Context, token *Token) error {
 	if err := db.WithContext(ctx).
 		Create(&token).Error; err != nil {
 		return err
@@ -74,10 +82,7 @@ func (t *Token) Insert(token *Token) error {
 	return nil
 }
 
-func (t *Token) DeleteAllForUser(scope string, userID int64) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
+func (t *Token) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	if err := db.WithContext(ctx).
 		Where("scope = ? AND user_id = ?", scope, userID).
 		Delete(&Token{}).Error; err != nil {
@@ -86,3 +91,59 @@ func (t *Token) DeleteAllForUser(scope string, userID int64) error {
 
 	return nil
 }
+
+// DeleteAllScopesForUser removes every token the user holds, whatever its scope
+func (t *Token) DeleteAllScopesForUser(ctx context.Context, userID int64) error {
+	if err := db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Delete(&Token{}).Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteAllForUserExcept removes every token of the given scope for the user
+// apart from the one matching tokenPlaintext, e.g. to keep the current session
+func (t *Token) DeleteAllForUserExcept(ctx context.Context, scope string, userID int64, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	if err := db.WithContext(ctx).
+		Where("scope = ? AND user_id = ? AND hash <> ?", scope, userID, tokenHash[:]).
+		Delete(&Token{}).Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *Token) GetForPlaintext(ctx context.Context, scope, tokenPlaintext string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	var token Token
+	err := db.WithContext(ctx).
+		Where("hash = ? AND scope = ?", tokenHash[:], scope).
+		Where("expiry > ?", time.Now()).
+		First(&token).Error
+
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &token, nil
+}
+
+// CountForUser returns the number of unexpired tokens of the given scope held by the user
+func (t *Token) CountForUser(ctx context.Context, scope string, userID int64) (int, error) {
+	var count int64
+	err := db.WithContext(ctx).
+		Model(&Token{}).
+		Where("scope = ? AND user_id = ?", scope, userID).
+		Where("expiry > ?", time.Now()).
+		Count(&count).Error
+
+	return int(count), err
+}
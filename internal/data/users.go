@@ -5,7 +5,8 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
-	"log"
+	"fmt"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -41,19 +42,19 @@ func (u *User) IsAnonymous() bool {
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
-	v.Check(email != "", "email", "must be provided")
-	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+	v.Check(email != "", "email", "required")
+	v.Check(validator.Matches(email, validator.EmailRX), "email", "invalid_email")
 }
 
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(password != "", "password", "required")
+	v.Check(len(password) >= 8, "password", "min_bytes", 8)
+	v.Check(len(password) <= 72, "password", "max_bytes", 72)
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(user.Name != "", "name", "required")
+	v.Check(len(user.Name) <= 500, "name", "max_bytes", 500)
 
 	ValidateEmail(v, user.Email)
 	if user.Password.plaintext != nil {
@@ -65,7 +66,6 @@ func ValidateUser(v *validator.Validator, user *User) {
 }
 
 func (p *password) Matches(Password string) (bool, error) {
-	log.Printf("Password hash: %s", p.hash)
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(Password))
 	if err != nil {
 		switch {
@@ -92,8 +92,8 @@ func (u *User) Set(plaintextPassword string) error {
 	return nil
 }
 
-func (u *User) Insert(user *User) error {
-	if err := db.Create(&user).Error; err != nil {
+func (u *User) Insert(ctx context.Context, user *User) error {
+	if err := db.WithContext(ctx).Create(&user).Error; err != nil {
 		switch {
 		case err.Error() == `ERROR: duplicate key value violates unique constraint "uni_users_email" (SQLSTATE 23505)`:
 			return ErrDuplicateEmail
@@ -105,10 +105,7 @@ func (u *User) Insert(user *User) error {
 	return nil
 }
 
-func (u *User) GetByEmail(email string) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
+func (u *User) GetByEmail(ctx context.Context, email string) (*User, error) {
 	var user User
 	if err := db.WithContext(ctx).
 		Where("email = ?", email).
@@ -120,10 +117,7 @@ func (u *User) GetByEmail(email string) (*User, error) {
 }
 
 // remember to update the Password field manually!!!
-func (u *User) Update(user *User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
+func (u *User) Update(ctx context.Context, user *User) error {
 	err := db.WithContext(ctx).
 		Model(&User{}).
 		Where("email = ?", user.Email).
@@ -147,10 +141,7 @@ func (u *User) Update(user *User) error {
 	return nil
 }
 
-func (u *User) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
+func (u *User) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	var user User
 	err := db.WithContext(ctx).
@@ -169,5 +160,88 @@ func (u *User) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
 		}
 	}
 
+	user.Password.hash = []byte(user.HashedPassword)
 	return &user, nil
 }
+
+func (u *User) Get(ctx context.Context, id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var user User
+	if err := db.WithContext(ctx).Where("id = ?", id).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	user.Password.hash = []byte(user.HashedPassword)
+	return &user, nil
+}
+
+// likeEscaper makes the LIKE wildcards in a search match literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// GetAll searches users whose email or name contains query, an empty query matches everyone
+func (u *User) GetAll(ctx context.Context, query string, filters Filters) ([]*User, Metadata, error) {
+	var users []*User
+	var totalRecord int64
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+
+	countQuery := db.WithContext(ctx).
+		Model(&User{}).
+		Where(`email ILIKE ? ESCAPE '\' OR name ILIKE ? ESCAPE '\' OR ? = ''`, pattern, pattern, query)
+
+	if err := countQuery.Count(&totalRecord).Error; err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err := db.WithContext(ctx).
+		Model(&User{}).
+		Where(`email ILIKE ? ESCAPE '\' OR name ILIKE ? ESCAPE '\' OR ? = ''`, pattern, pattern, query).
+		Order(fmt.Sprintf("%s %s, id ASC", filters.sortColumn(), filters.sortDirection())).
+		Limit(filters.limit()).
+		Offset(filters.offset()).
+		Find(&users).Error
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	if len(users) == 0 {
+		return users, Metadata{}, nil
+	}
+
+	metadata := calculateMetadata(int(totalRecord), filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
+// Delete removes the user together with their tokens, permission grants and
+// movie collaborations
+func (u *User) Delete(ctx context.Context, id int64) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", id).Delete(&Token{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", id).Delete(&MovieCollaborator{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("DELETE FROM users_permissions WHERE user_id = ?", id).Error; err != nil {
+			return err
+		}
+
+		result := tx.Where("id = ?", id).Delete(&User{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,96 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLanguage is used when the client accepts none of the languages we
+// have a catalog for, and for codes missing from another catalog
+const DefaultLanguage = "en"
+
+//go:embed locales/*.json
+var localesFS embed.FS
+
+// catalogs maps a language tag to its messages, keyed by stable message code
+var catalogs = map[string]map[string]string{}
+
+func init() {
+	files, err := localesFS.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, file := range files {
+		content, err := localesFS.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		messages := map[string]string{}
+		if err := json.Unmarshal(content, &messages); err != nil {
+			panic(fmt.Sprintf("i18n: invalid catalog %s: %s", file.Name(), err))
+		}
+
+		catalogs[strings.TrimSuffix(file.Name(), ".json")] = messages
+	}
+}
+
+// Languages returns the tags we have a catalog for
+func Languages() []string {
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+
+	return langs
+}
+
+// Negotiate picks the supported language the client prefers most according to
+// an Accept-Language header, matching on the primary subtag so zh-CN gets zh
+func Negotiate(acceptLanguage string) string {
+	best, bestQ := DefaultLanguage, 0.0
+
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, found := catalogs[primary]; found && q > bestQ {
+			best, bestQ = primary, q
+		}
+	}
+
+	return best
+}
+
+// T renders the message for code in lang, falling back to the default language
+// and finally to the code itself so a missing translation never loses the error
+func T(lang, code string, args ...any) string {
+	message, found := catalogs[lang][code]
+	if !found {
+		message, found = catalogs[DefaultLanguage][code]
+	}
+	if !found {
+		return code
+	}
+
+	if len(args) == 0 {
+		return message
+	}
+
+	return fmt.Sprintf(message, args...)
+}
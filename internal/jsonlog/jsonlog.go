@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
+	"os"
 	"runtime/debug"
 	"strings"
 	"sync"
@@ -57,6 +58,7 @@ func (l *Logger) PrintError(err error, properties map[string]string) {
 
 func (l *Logger) PrintFatal(err error, properties map[string]string) {
 	l.print(LevelFatal, err.Error(), properties)
+	os.Exit(1)
 }
 
 func formattedStackString() string {
@@ -2,12 +2,21 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	// "log"
+	"net"
+	"strconv"
 	"text/template"
 	"time"
 
 	"github.com/go-mail/mail/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"greenlight.fyerfyer.net/internal/metrics"
+	"greenlight.fyerfyer.net/internal/requestid"
+	"greenlight.fyerfyer.net/internal/tracing"
 )
 
 //go:embed "templates"
@@ -27,7 +36,35 @@ func New(host string, port int, username, password, sender string) Mailer {
 	}
 }
 
-func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
+// Ping checks the SMTP server accepts connections, without logging in
+func (m Mailer) Ping(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.dialer.Host, strconv.Itoa(m.dialer.Port)))
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// Send renders templateFile with data and mails it to recipient, the ID of the
+// request carried by ctx is added as an X-Request-ID header
+func (m Mailer) Send(ctx context.Context, recipient, templateFile string, data interface{}) (err error) {
+	_, span := tracing.Tracer().Start(ctx, "mailer.Send", trace.WithAttributes(
+		attribute.String("mail.template", templateFile),
+	))
+
+	defer func() {
+		outcome := "success"
+		if err != nil {
+			outcome = "failure"
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		metrics.MailSends.WithLabelValues(templateFile, outcome).Inc()
+		span.End()
+	}()
+
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
 		return err
@@ -56,14 +93,38 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
 	msg.SetHeader("To", recipient)
 	msg.SetHeader("From", m.sender)
 	msg.SetHeader("Subject", subject.String())
+	if id := requestid.FromContext(ctx); id != "" {
+		msg.SetHeader(requestid.Header, id)
+	}
 	msg.SetBody("text/plain", plainBody.String())
 	msg.AddAlternative("text/html", htmlBody.String())
 
-	// pass in the msg to send
-	err = m.dialer.DialAndSend(msg)
-	if err != nil {
+	return m.dialAndSend(ctx, msg)
+}
+
+// dialAndSend sends msg, giving up when ctx is done. The SMTP client takes no
+// context, so each socket operation is bounded by the time left on ctx and the
+// send is abandoned to finish or time out on its own if ctx ends first
+func (m Mailer) dialAndSend(ctx context.Context, msg *mail.Message) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	return nil
+	// a copy per send, so the deadline doesn't leak into concurrent sends
+	dialer := *m.dialer
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Timeout = min(dialer.Timeout, time.Until(deadline))
+	}
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- dialer.DialAndSend(msg)
+	}()
+
+	select {
+	case err := <-sent:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
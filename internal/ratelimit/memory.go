@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Memory keeps one token bucket per key in process memory, so every replica
+// of the API enforces its own quota
+type Memory struct {
+	mu      sync.Mutex
+	clients map[string]*client
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewMemory returns an in-memory limiter, buckets of clients that haven't been
+// seen for 3 minutes are dropped every minute
+func NewMemory() *Memory {
+	m := &Memory{
+		clients: make(map[string]*client),
+	}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			m.mu.Lock()
+			for key, client := range m.clients {
+				if time.Since(client.lastSeen) > 3*time.Minute {
+					delete(m.clients, key)
+				}
+			}
+
+			m.mu.Unlock()
+		}
+	}()
+
+	return m
+}
+
+func (m *Memory) Allow(_ context.Context, key string, limit Limit) (Result, error) {
+	// the same key may be limited by different quotas, keep a bucket per quota
+	key = fmt.Sprintf("%s:%g:%d", key, limit.Rate, limit.Burst)
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.clients[key]; !found {
+		m.clients[key] = &client{
+			limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst),
+		}
+	}
+
+	c := m.clients[key]
+	c.lastSeen = now
+
+	result := Result{Limit: limit.Burst}
+
+	reservation := c.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); !reservation.OK() || delay > 0 {
+		reservation.CancelAt(now)
+		result.RetryAfter = delay
+	} else {
+		result.Allowed = true
+	}
+
+	tokens := c.limiter.TokensAt(now)
+	result.Remaining = int(math.Max(0, math.Floor(tokens)))
+	if limit.Rate > 0 {
+		missing := float64(limit.Burst) - tokens
+		result.ResetAfter = time.Duration(missing / limit.Rate * float64(time.Second))
+	}
+
+	return result, nil
+}
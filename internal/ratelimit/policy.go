@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Quota is the JSON form of a Limit
+type Quota struct {
+	Rps   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+}
+
+func (q Quota) Limit() Limit {
+	return Limit{Rate: q.Rps, Burst: q.Burst}
+}
+
+func (q Quota) validate(name string) error {
+	if q.Rps <= 0 || q.Burst <= 0 {
+		return fmt.Errorf("ratelimit: %s: rps and burst must be greater than zero", name)
+	}
+
+	return nil
+}
+
+// RoutePolicy overrides the quotas for every route whose pattern starts with
+// Prefix, restricted to Methods when any are given; a quota left out falls back
+// to the policy-wide one
+type RoutePolicy struct {
+	Name          string   `json:"name"`
+	Prefix        string   `json:"prefix"`
+	Methods       []string `json:"methods,omitempty"`
+	Anonymous     *Quota   `json:"anonymous,omitempty"`
+	Authenticated *Quota   `json:"authenticated,omitempty"`
+}
+
+// Policy decides which quota applies to a request, clients identified by a user
+// ID get the authenticated quota and everyone else the anonymous one
+type Policy struct {
+	Anonymous     Quota         `json:"anonymous"`
+	Authenticated Quota         `json:"authenticated"`
+	Routes        []RoutePolicy `json:"routes"`
+}
+
+// LoadPolicy reads a policy file, when path is empty every client and route
+// gets the fallback quota
+func LoadPolicy(path string, fallback Quota) (*Policy, error) {
+	policy := &Policy{
+		Anonymous:     fallback,
+		Authenticated: fallback,
+	}
+
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(content, policy); err != nil {
+			return nil, fmt.Errorf("ratelimit: invalid policy: %w", err)
+		}
+	}
+
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func (p *Policy) validate() error {
+	errs := []error{
+		p.Anonymous.validate("anonymous"),
+		p.Authenticated.validate("authenticated"),
+	}
+
+	for i, route := range p.Routes {
+		if route.Name == "" || route.Prefix == "" {
+			errs = append(errs, fmt.Errorf("ratelimit: route %d: name and prefix must be provided", i))
+		}
+		if route.Anonymous != nil {
+			errs = append(errs, route.Anonymous.validate(route.Name+".anonymous"))
+		}
+		if route.Authenticated != nil {
+			errs = append(errs, route.Authenticated.validate(route.Name+".authenticated"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Resolve returns the name of the bucket group and the limit for a request to
+// the gin route pattern, the first matching route policy wins
+func (p *Policy) Resolve(method, path string, authenticated bool) (string, Limit) {
+	for _, route := range p.Routes {
+		if !strings.HasPrefix(path, route.Prefix) || !matchMethod(route.Methods, method) {
+			continue
+		}
+
+		switch {
+		case authenticated && route.Authenticated != nil:
+			return route.Name, route.Authenticated.Limit()
+		case !authenticated && route.Anonymous != nil:
+			return route.Name, route.Anonymous.Limit()
+		}
+	}
+
+	if authenticated {
+		return "default", p.Authenticated.Limit()
+	}
+
+	return "default", p.Anonymous.Limit()
+}
+
+func matchMethod(methods []string, method string) bool {
+	if len(methods) == 0 {
+		return true
+	}
+
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+
+	return false
+}
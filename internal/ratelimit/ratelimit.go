@@ -0,0 +1,30 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit is a token bucket quota: Rate requests per second on average with
+// bursts of up to Burst requests
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result reports the outcome of a single Allow call
+// RetryAfter: how long until the next request would be allowed, zero when allowed
+// ResetAfter: how long until the bucket is full again
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	ResetAfter time.Duration
+}
+
+// Limiter is implemented by every rate limiter backend, keys are opaque to
+// the backend and usually identify a client
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// gcra implements the generic cell rate algorithm in a single round trip, the
+// only state kept per key is the theoretical arrival time (TAT) of the next
+// request, expressed in seconds since the epoch according to the server clock
+//
+// KEYS[1]: the bucket key
+// ARGV[1]: burst, ARGV[2]: rate per second
+// returns {allowed, remaining, retry_after, reset_after}, durations in seconds
+var gcra = redis.NewScript(`
+redis.replicate_commands()
+
+local key = KEYS[1]
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+
+local emission_interval = 1 / rate
+local burst_offset = emission_interval * burst
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+
+local tat = tonumber(redis.call("GET", key))
+if not tat then
+	tat = now
+end
+tat = math.max(tat, now)
+
+local new_tat = tat + emission_interval
+local diff = now - (new_tat - burst_offset)
+local remaining = math.floor(diff / emission_interval)
+
+if remaining < 0 then
+	return {0, 0, tostring(-diff), tostring(tat - now)}
+end
+
+local reset_after = new_tat - now
+redis.call("SET", key, tostring(new_tat), "EX", math.ceil(reset_after))
+return {1, remaining, "0", tostring(reset_after)}
+`)
+
+// Redis shares token buckets between every replica through a Redis compatible
+// server, so a client gets the same quota whichever replica serves it
+type Redis struct {
+	client redis.Scripter
+	prefix string
+}
+
+// NewRedis connects to the server at url, e.g. redis://localhost:6379/0,
+// every key it writes is prefixed with prefix
+func NewRedis(url, prefix string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, err
+	}
+
+	return &Redis{
+		client: client,
+		prefix: prefix,
+	}, nil
+}
+
+func (r *Redis) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	key = r.prefix + key + ":" + strconv.FormatFloat(limit.Rate, 'g', -1, 64) + ":" + strconv.Itoa(limit.Burst)
+
+	values, err := gcra.Run(ctx, r.client, []string{key}, limit.Burst, limit.Rate).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	// servers speaking another dialect may shape the reply differently, which
+	// is an error for the caller to handle rather than a panic
+	if len(values) != 4 {
+		return Result{}, fmt.Errorf("ratelimit: unexpected gcra reply %v", values)
+	}
+	allowed, ok1 := values[0].(int64)
+	remaining, ok2 := values[1].(int64)
+	retryAfterText, ok3 := values[2].(string)
+	resetAfterText, ok4 := values[3].(string)
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return Result{}, fmt.Errorf("ratelimit: unexpected gcra reply %v", values)
+	}
+
+	retryAfter, err := strconv.ParseFloat(retryAfterText, 64)
+	if err != nil {
+		return Result{}, err
+	}
+
+	resetAfter, err := strconv.ParseFloat(resetAfterText, 64)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Allowed:    allowed == 1,
+		Limit:      limit.Burst,
+		Remaining:  int(remaining),
+		RetryAfter: time.Duration(retryAfter * float64(time.Second)),
+		ResetAfter: time.Duration(resetAfter * float64(time.Second)),
+	}, nil
+}
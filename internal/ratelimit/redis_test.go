@@ -0,0 +1,168 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) (*Redis, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	server.SetTime(time.Unix(1_700_000_000, 0))
+
+	limiter, err := NewRedis("redis://"+server.Addr()+"/0", "test:")
+	if err != nil {
+		t.Fatalf("NewRedis: %v", err)
+	}
+
+	return limiter, server
+}
+
+// advance moves both the clock the script reads and the key expiry forward
+func advance(server *miniredis.Miniredis, now *time.Time, d time.Duration) {
+	*now = now.Add(d)
+	server.SetTime(*now)
+	server.FastForward(d)
+}
+
+func TestRedisAllow(t *testing.T) {
+	limiter, server := newTestRedis(t)
+	now := time.Unix(1_700_000_000, 0)
+	limit := Limit{Rate: 1, Burst: 3}
+
+	tests := []struct {
+		name       string
+		advance    time.Duration
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+		resetAfter time.Duration
+	}{
+		{name: "first request", allowed: true, remaining: 2, resetAfter: time.Second},
+		{name: "second request", allowed: true, remaining: 1, resetAfter: 2 * time.Second},
+		{name: "burst used up", allowed: true, remaining: 0, resetAfter: 3 * time.Second},
+		{name: "over the burst", allowed: false, remaining: 0, retryAfter: time.Second, resetAfter: 3 * time.Second},
+		{name: "a token refilled", advance: time.Second, allowed: true, remaining: 0, resetAfter: 3 * time.Second},
+		{name: "half a token refilled", advance: 500 * time.Millisecond, allowed: false, remaining: 0, retryAfter: 500 * time.Millisecond, resetAfter: 2500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		advance(server, &now, tt.advance)
+
+		result, err := limiter.Allow(context.Background(), "ip:203.0.113.9", limit)
+		if err != nil {
+			t.Fatalf("%s: Allow: %v", tt.name, err)
+		}
+
+		if result.Allowed != tt.allowed {
+			t.Errorf("%s: allowed = %t, want %t", tt.name, result.Allowed, tt.allowed)
+		}
+		if result.Limit != limit.Burst {
+			t.Errorf("%s: limit = %d, want %d", tt.name, result.Limit, limit.Burst)
+		}
+		if result.Remaining != tt.remaining {
+			t.Errorf("%s: remaining = %d, want %d", tt.name, result.Remaining, tt.remaining)
+		}
+		if !closeTo(result.RetryAfter, tt.retryAfter) {
+			t.Errorf("%s: retry after = %s, want %s", tt.name, result.RetryAfter, tt.retryAfter)
+		}
+		if !closeTo(result.ResetAfter, tt.resetAfter) {
+			t.Errorf("%s: reset after = %s, want %s", tt.name, result.ResetAfter, tt.resetAfter)
+		}
+	}
+}
+
+func TestRedisKeyExpiry(t *testing.T) {
+	limiter, server := newTestRedis(t)
+	now := time.Unix(1_700_000_000, 0)
+	limit := Limit{Rate: 1, Burst: 3}
+	key := "test:ip:203.0.113.9:1:3"
+
+	for range 3 {
+		if _, err := limiter.Allow(context.Background(), "ip:203.0.113.9", limit); err != nil {
+			t.Fatalf("Allow: %v", err)
+		}
+	}
+
+	// the key lives until the bucket is full again and not longer
+	if ttl := server.TTL(key); ttl != 3*time.Second {
+		t.Fatalf("ttl = %s, want 3s", ttl)
+	}
+
+	advance(server, &now, 3*time.Second)
+	if server.Exists(key) {
+		t.Fatal("key still exists once the bucket is full")
+	}
+
+	result, err := limiter.Allow(context.Background(), "ip:203.0.113.9", limit)
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if !result.Allowed || result.Remaining != 2 {
+		t.Errorf("got allowed = %t and remaining = %d, want a full bucket", result.Allowed, result.Remaining)
+	}
+}
+
+func TestRedisSeparateBuckets(t *testing.T) {
+	limiter, _ := newTestRedis(t)
+	limit := Limit{Rate: 1, Burst: 1}
+
+	for _, key := range []string{"ip:203.0.113.9", "ip:203.0.113.10", "user:1"} {
+		result, err := limiter.Allow(context.Background(), key, limit)
+		if err != nil {
+			t.Fatalf("Allow: %v", err)
+		}
+		if !result.Allowed {
+			t.Errorf("%s: first request was denied", key)
+		}
+	}
+
+	// a different quota for the same client gets its own bucket
+	result, err := limiter.Allow(context.Background(), "user:1", Limit{Rate: 2, Burst: 2})
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if !result.Allowed {
+		t.Error("request under another quota was denied")
+	}
+}
+
+// replyScripter answers every script with a fixed reply
+type replyScripter struct {
+	redis.Scripter
+	reply any
+}
+
+func (s replyScripter) EvalSha(ctx context.Context, sha1 string, keys []string, args ...any) *redis.Cmd {
+	return redis.NewCmdResult(s.reply, nil)
+}
+
+func TestRedisUnexpectedReply(t *testing.T) {
+	replies := map[string]any{
+		"too short":       []any{int64(1), int64(2)},
+		"wrong types":     []any{"1", "2", int64(0), int64(1)},
+		"not a number":    []any{int64(1), int64(2), "0", "soon"},
+		"nested table":    []any{[]any{int64(1)}, int64(2), "0", "1"},
+		"float remaining": []any{int64(1), 2.5, "0", "1"},
+	}
+
+	for name, reply := range replies {
+		t.Run(name, func(t *testing.T) {
+			limiter := &Redis{client: replyScripter{reply: reply}, prefix: "test:"}
+
+			if _, err := limiter.Allow(context.Background(), "ip:203.0.113.9", Limit{Rate: 1, Burst: 1}); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func closeTo(got, want time.Duration) bool {
+	diff := got - want
+	return diff > -time.Millisecond && diff < time.Millisecond
+}
@@ -0,0 +1,149 @@
+package realip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// headers lists the forwarding headers a resolver can read, keyed by their
+// lower case name
+var headers = map[string]func(values []string) []string{
+	"forwarded":       parseForwarded,
+	"x-forwarded-for": parseList,
+	"x-real-ip": func(values []string) []string {
+		return []string{strings.TrimSpace(values[len(values)-1])}
+	},
+}
+
+// Resolver works out the address of the client behind any trusted proxies.
+// Forwarding headers are only believed when the peer they came from is a
+// trusted proxy, otherwise anyone could pick the address we see
+type Resolver struct {
+	trusted []*net.IPNet
+	header  string
+	parse   func(values []string) []string
+}
+
+// New builds a resolver trusting the given CIDRs, a bare IP trusts that address
+// only. header is the one forwarding header the proxies set, Forwarded,
+// X-Forwarded-For or X-Real-IP; the others are ignored because a proxy passes
+// them on from the client untouched
+func New(proxies []string, header string) (*Resolver, error) {
+	parse, found := headers[strings.ToLower(header)]
+	if !found {
+		return nil, fmt.Errorf("realip: unsupported header %q, use Forwarded, X-Forwarded-For or X-Real-IP", header)
+	}
+
+	r := &Resolver{header: header, parse: parse}
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("realip: invalid trusted proxy %q", proxy)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			r.trusted = append(r.trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("realip: invalid trusted proxy %q: %w", proxy, err)
+		}
+		r.trusted = append(r.trusted, network)
+	}
+
+	return r, nil
+}
+
+func (r *Resolver) isTrusted(ip net.IP) bool {
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientIP returns the client address for the request. The forwarding chain of
+// the configured header is walked from the nearest hop outwards and the first
+// address that isn't a trusted proxy wins
+func (r *Resolver) ClientIP(req *http.Request) string {
+	remote, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remote = req.RemoteAddr
+	}
+
+	remoteIP := net.ParseIP(remote)
+	if remoteIP == nil || !r.isTrusted(remoteIP) {
+		return remote
+	}
+
+	var chain []string
+	if values := req.Header.Values(r.header); len(values) > 0 {
+		chain = r.parse(values)
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := parseNode(chain[i])
+		if ip == nil {
+			// we can't tell who sent anything before a malformed hop,
+			// so the last proxy we trust is as far as we go
+			break
+		}
+
+		if !r.isTrusted(ip) {
+			return ip.String()
+		}
+		remote = ip.String()
+	}
+
+	return remote
+}
+
+// parseList splits comma separated header values into their elements
+func parseList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			list = append(list, strings.TrimSpace(element))
+		}
+	}
+
+	return list
+}
+
+// parseForwarded extracts the for= node of each element of RFC 7239 Forwarded
+// headers, e.g. `for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"`
+func parseForwarded(values []string) []string {
+	var nodes []string
+	for _, element := range parseList(values) {
+		node := ""
+		for _, pair := range strings.Split(element, ";") {
+			key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+			if found && strings.EqualFold(key, "for") {
+				node = strings.Trim(value, `"`)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
+
+// parseNode reads an address that may carry a port or IPv6 brackets, returning
+// nil for obfuscated identifiers and "unknown"
+func parseNode(node string) net.IP {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+
+	return net.ParseIP(strings.Trim(node, "[]"))
+}
@@ -0,0 +1,226 @@
+package realip
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies := []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"}
+
+	tests := []struct {
+		name    string
+		header  string
+		remote  string
+		headers map[string][]string
+		want    string
+	}{
+		{
+			name:   "untrusted peer headers are ignored",
+			header: "X-Forwarded-For",
+			remote: "198.51.100.7:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"1.1.1.1"},
+			},
+			want: "198.51.100.7",
+		},
+		{
+			name:   "no forwarding header",
+			header: "X-Forwarded-For",
+			remote: "10.0.0.5:5000",
+			want:   "10.0.0.5",
+		},
+		{
+			name:   "client Forwarded is ignored when the proxy sets X-Forwarded-For",
+			header: "X-Forwarded-For",
+			remote: "10.0.0.5:5000",
+			headers: map[string][]string{
+				"Forwarded":       {"for=1.1.1.1"},
+				"X-Forwarded-For": {"203.0.113.9"},
+			},
+			want: "203.0.113.9",
+		},
+		{
+			name:   "client X-Forwarded-For is ignored when the proxy sets X-Real-IP",
+			header: "X-Real-IP",
+			remote: "10.0.0.5:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"8.8.8.8"},
+				"X-Real-Ip":       {"203.0.113.9"},
+			},
+			want: "203.0.113.9",
+		},
+		{
+			name:   "client X-Real-IP is ignored when the proxy sets Forwarded",
+			header: "Forwarded",
+			remote: "10.0.0.5:5000",
+			headers: map[string][]string{
+				"X-Real-Ip": {"8.8.8.8"},
+				"Forwarded": {"for=203.0.113.9;proto=https"},
+			},
+			want: "203.0.113.9",
+		},
+		{
+			name:   "missing configured header falls back to the peer",
+			header: "X-Real-IP",
+			remote: "10.0.0.5:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"8.8.8.8"},
+			},
+			want: "10.0.0.5",
+		},
+		{
+			name:   "addresses the client prepended are skipped",
+			header: "X-Forwarded-For",
+			remote: "10.0.0.5:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"8.8.8.8, 203.0.113.9"},
+			},
+			want: "203.0.113.9",
+		},
+		{
+			name:   "trusted hops are walked right to left",
+			header: "X-Forwarded-For",
+			remote: "10.0.0.5:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"8.8.8.8, 203.0.113.9, 10.1.1.1", "192.0.2.1"},
+			},
+			want: "203.0.113.9",
+		},
+		{
+			name:   "every hop trusted",
+			header: "X-Forwarded-For",
+			remote: "10.0.0.5:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"10.2.2.2, 10.1.1.1"},
+			},
+			want: "10.2.2.2",
+		},
+		{
+			name:   "malformed hop stops the walk at the last trusted proxy",
+			header: "X-Forwarded-For",
+			remote: "10.0.0.5:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"203.0.113.9, garbage, 10.1.1.1"},
+			},
+			want: "10.1.1.1",
+		},
+		{
+			name:   "Forwarded walked right to left",
+			header: "Forwarded",
+			remote: "10.0.0.5:5000",
+			headers: map[string][]string{
+				"Forwarded": {"for=8.8.8.8, for=203.0.113.9;proto=https", "for=10.1.1.1"},
+			},
+			want: "203.0.113.9",
+		},
+		{
+			name:   "Forwarded IPv4 with port",
+			header: "Forwarded",
+			remote: "10.0.0.5:5000",
+			headers: map[string][]string{
+				"Forwarded": {`for="203.0.113.9:4711"`},
+			},
+			want: "203.0.113.9",
+		},
+		{
+			name:   "Forwarded bracketed IPv6",
+			header: "Forwarded",
+			remote: "10.0.0.5:5000",
+			headers: map[string][]string{
+				"Forwarded": {`for="[2001:db9::1]"`},
+			},
+			want: "2001:db9::1",
+		},
+		{
+			name:   "Forwarded bracketed IPv6 with port",
+			header: "Forwarded",
+			remote: "10.0.0.5:5000",
+			headers: map[string][]string{
+				"Forwarded": {`for="[2001:db9::1]:4711";proto=https`},
+			},
+			want: "2001:db9::1",
+		},
+		{
+			name:   "Forwarded trusted IPv6 hop",
+			header: "Forwarded",
+			remote: "[2001:db8::5]:443",
+			headers: map[string][]string{
+				"Forwarded": {`for=203.0.113.9, for="[2001:db8::7]:80"`},
+			},
+			want: "203.0.113.9",
+		},
+		{
+			name:   "Forwarded for key is case insensitive",
+			header: "Forwarded",
+			remote: "10.0.0.5:5000",
+			headers: map[string][]string{
+				"Forwarded": {"proto=https;For=203.0.113.9"},
+			},
+			want: "203.0.113.9",
+		},
+		{
+			name:   "Forwarded obfuscated identifier",
+			header: "Forwarded",
+			remote: "10.0.0.5:5000",
+			headers: map[string][]string{
+				"Forwarded": {"for=_hidden, for=10.1.1.1"},
+			},
+			want: "10.1.1.1",
+		},
+		{
+			name:   "configured header name is case insensitive",
+			header: "x-forwarded-for",
+			remote: "10.0.0.5:5000",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"203.0.113.9"},
+			},
+			want: "203.0.113.9",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver, err := New(proxies, tt.header)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.RemoteAddr = tt.remote
+			for name, values := range tt.headers {
+				req.Header[name] = values
+			}
+
+			if got := resolver.ClientIP(req); got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		header  string
+		valid   bool
+	}{
+		{name: "CIDRs and IPs", proxies: []string{"10.0.0.0/8", "192.0.2.1", "::1"}, header: "Forwarded", valid: true},
+		{name: "invalid IP", proxies: []string{"10.0.0.256"}, header: "X-Forwarded-For"},
+		{name: "invalid CIDR", proxies: []string{"10.0.0.0/33"}, header: "X-Forwarded-For"},
+		{name: "unsupported header", header: "True-Client-IP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.proxies, tt.header)
+			if (err == nil) != tt.valid {
+				t.Errorf("err = %v, want valid = %t", err, tt.valid)
+			}
+		})
+	}
+}
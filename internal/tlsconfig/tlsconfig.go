@@ -0,0 +1,157 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configures TLS termination
+// MinVersion: 1.2 or 1.3
+// CipherSuites: IANA names of the TLS 1.2 suites to allow, Go's defaults when empty
+// ReloadInterval: how often the certificate files are checked for changes
+type Options struct {
+	CertFile       string
+	KeyFile        string
+	MinVersion     string
+	CipherSuites   []string
+	HTTP2          bool
+	ReloadInterval time.Duration
+}
+
+var versions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// New builds a server TLS config whose certificate is reloaded when the files
+// change on disk, so renewed certificates are picked up without a restart.
+// onReloadError is told about certificates that failed to load, the previous
+// one keeps being served meanwhile
+func New(opts Options, onReloadError func(error)) (*tls.Config, error) {
+	minVersion, found := versions[opts.MinVersion]
+	if !found {
+		return nil, fmt.Errorf("tlsconfig: unsupported minimum version %q", opts.MinVersion)
+	}
+
+	suites, err := cipherSuites(opts.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	reloader := &reloader{
+		certFile: opts.CertFile,
+		keyFile:  opts.KeyFile,
+		interval: opts.ReloadInterval,
+		onError:  onReloadError,
+	}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+
+	nextProtos := []string{"http/1.1"}
+	if opts.HTTP2 {
+		nextProtos = []string{"h2", "http/1.1"}
+	}
+
+	return &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   suites,
+		NextProtos:     nextProtos,
+		GetCertificate: reloader.getCertificate,
+	}, nil
+}
+
+func cipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, found := known[strings.ToUpper(name)]
+		if !found {
+			return nil, fmt.Errorf("tlsconfig: unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// reloader serves the certificate from disk, checking at most once per interval
+// whether either file was modified since it was loaded
+type reloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	onError  func(error)
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func (r *reloader) load() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return fmt.Errorf("tlsconfig: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("tlsconfig: %w", err)
+	}
+
+	r.cert, r.modTime = &cert, modTime
+	return nil
+}
+
+func (r *reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+func (r *reloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checkedAt) < r.interval {
+		return r.cert, nil
+	}
+	r.checkedAt = time.Now()
+
+	// the files may be mid-rotation, keep serving the old pair until both load
+	modTime, err := r.latestModTime()
+	if err == nil && modTime.Equal(r.modTime) {
+		return r.cert, nil
+	}
+	if err == nil {
+		err = r.load()
+	}
+	if err != nil && r.onError != nil {
+		r.onError(err)
+	}
+
+	return r.cert, nil
+}
@@ -6,27 +6,34 @@ var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 )
 
+// Error is a failed check, identified by a stable message code so it can be
+// rendered in the client's language, with any values the message refers to
+type Error struct {
+	Code string
+	Args []any
+}
+
 type Validator struct {
-	Errors map[string]string
+	Errors map[string]Error
 }
 
 func New() *Validator {
-	return &Validator{make(map[string]string)}
+	return &Validator{make(map[string]Error)}
 }
 
 func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
 
-func (v *Validator) AddError(key, message string) {
+func (v *Validator) AddError(key, code string, args ...any) {
 	if _, exist := v.Errors[key]; !exist {
-		v.Errors[key] = message
+		v.Errors[key] = Error{Code: code, Args: args}
 	}
 }
 
-func (v *Validator) Check(ok bool, key, message string) {
+func (v *Validator) Check(ok bool, key, code string, args ...any) {
 	if !ok {
-		v.AddError(key, message)
+		v.AddError(key, code, args...)
 	}
 }
 